@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/zduymz/tag-to-label/pkg/controller"
+	"github.com/zduymz/tag-to-label/pkg/provider"
+)
+
+// runKubeletLabels prints the labels a node should register with, for user data like:
+// /etc/eks/bootstrap.sh my-cluster --kubelet-extra-args "--node-labels=$(tag-to-label kubelet-labels)"
+func runKubeletLabels(args []string) error {
+	flags := flag.NewFlagSet("kubelet-labels", flag.ExitOnError)
+	klog.InitFlags(flags)
+	source := flags.String("source", "imds", "where tags are read from: imds (instance metadata tags) or ec2 (DescribeTags)")
+	imdsEndpoint := flags.String("imds.endpoint", "", "instance metadata endpoint, default http://169.254.169.254")
+	imdsTagPrefix := flags.String("imds.tag-prefix", "", "prefix of instance tags read as "+controller.TagNamePrefix+" from metadata, default "+strings.TrimSuffix(controller.TagNamePrefix, "/")+":")
+	region := flags.String("aws.region", "", "aws region, default the region of the instance")
+	timeout := flags.Duration("request-timeout", 10*time.Second, "timeout of metadata and aws requests")
+	flags.Parse(args)
+	if *imdsTagPrefix == "" {
+		*imdsTagPrefix = strings.TrimSuffix(controller.TagNamePrefix, "/") + ":"
+	}
+
+	imds, err := provider.NewIMDSProvider(provider.IMDSConfig{
+		Endpoint:     *imdsEndpoint,
+		Timeout:      *timeout,
+		TagKeyPrefix: *imdsTagPrefix,
+		TagPrefix:    controller.TagNamePrefix,
+	})
+	if err != nil {
+		return err
+	}
+
+	var tags []*provider.Tag
+	switch *source {
+	case "imds":
+		if tags, err = imds.InstanceTags(); err != nil {
+			return err
+		}
+		if !hasTagPrefix(tags, controller.TagNamePrefix) {
+			// keys with '/' never show up in metadata, the instance has to use the -imds.tag-prefix form
+			klog.Warningf("Instance metadata has no tags with prefix %s, tag the instance with those keys or use -source=ec2", *imdsTagPrefix)
+		}
+	case "ec2":
+		id, zone, instanceRegion, err := imds.Placement()
+		if err != nil {
+			return err
+		}
+		if *region == "" {
+			*region = instanceRegion
+		}
+		p, err := provider.NewAWSProvider(provider.AWSConfig{
+			Region:     *region,
+			APIRetries: 3,
+			TagKeys:    []string{controller.TagNamePrefix + "*"},
+		})
+		if err != nil {
+			return err
+		}
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: id},
+			Spec:       corev1.NodeSpec{ProviderID: fmt.Sprintf("aws:///%s/%s", zone, id)},
+		}
+		byId, err := p.ListTags([]*corev1.Node{node})
+		if err != nil {
+			return err
+		}
+		tags = byId[id]
+	default:
+		return fmt.Errorf("unknown source %q, expected imds or ec2", *source)
+	}
+
+	labels, rejected := controller.KubeletLabels(tags)
+	for _, reason := range rejected {
+		klog.Warningf("Skipping label %s", reason)
+	}
+	// only the value goes to stdout, logs go to stderr
+	fmt.Println(controller.FormatNodeLabels(labels))
+	return nil
+}
+
+func hasTagPrefix(tags []*provider.Tag, prefix string) bool {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag.Key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -17,6 +22,13 @@ import (
 var config tag_to_label.Config
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "kubelet-labels" {
+		if err := runKubeletLabels(os.Args[2:]); err != nil {
+			klog.Fatalf("Error getting kubelet labels: %s", err.Error())
+		}
+		return
+	}
+
 	klog.InitFlags(nil)
 	flag.Parse()
 
@@ -35,24 +47,98 @@ func main() {
 
 	// (client kubernetes.Interface, defaultResync time.Duration)
 	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
+	podInformerFactory := kubeInformerFactory
+	if config.NodeName != "" {
+		// one replica per node only needs to see its own node and pods
+		klog.Infof("Only watching node %s", config.NodeName)
+		kubeInformerFactory = newFilteredInformerFactory(kubeClient, "metadata.name", config.NodeName)
+		podInformerFactory = newFilteredInformerFactory(kubeClient, "spec.nodeName", config.NodeName)
+	}
 
-	controller, err := controller.NewController(kubeInformerFactory.Core().V1().Nodes(), kubeInformerFactory.Core().V1().Pods(), kubeClient, &config)
+	controller, err := controller.NewController(kubeInformerFactory.Core().V1().Nodes(), podInformerFactory.Core().V1().Pods(), kubeClient, &config)
 	if err != nil {
 		klog.Fatalf("Error building kubernetes controller: %s", err.Error())
 	}
 
+	if config.MetricsAddress != "" {
+		go serveMetrics(config.MetricsAddress)
+	}
+
 	kubeInformerFactory.Start(stopCh)
+	podInformerFactory.Start(stopCh)
 
 	if err = controller.Run(stopCh); err != nil {
 		klog.Fatalf("Error running controller: %s", err.Error())
 	}
 }
 
+// newFilteredInformerFactory makes informers only listing objects whose field has value
+func newFilteredInformerFactory(client kubernetes.Interface, field, value string) kubeinformers.SharedInformerFactory {
+	return kubeinformers.NewSharedInformerFactoryWithOptions(client, time.Second*30,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector(field, value).String()
+		}))
+}
+
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	klog.Infof("Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		klog.Fatalf("Error serving metrics: %s", err.Error())
+	}
+}
+
 func init() {
 	flag.StringVar(&config.KubeConfig, "kubeconfig", "", "kubeconfig")
 	flag.StringVar(&config.Master, "master", "", "master url")
-	flag.StringVar(&config.AWSRegion, "aws.region", "us-west-2", "aws region")
+	flag.StringVar(&config.Provider, "provider", "aws", "tag source: aws, gce, azure, static, exec, annotation, imds, replay")
+	flag.StringVar(&config.NodeName, "node-name", "", "only watch and label this node, e.g. when running on every node")
+	flag.DurationVar(&config.RequestTimeout, "request-timeout", 10*time.Second, "timeout of provider api requests")
+	flag.DurationVar(&config.CacheTTL, "cache.ttl", 4*time.Minute, "how long tags of an instance are reused, 0 disables caching")
+	flag.DurationVar(&config.CacheNegativeTTL, "cache.negative-ttl", 30*time.Second, "how long instances without tags are remembered")
+	flag.StringVar(&config.MetricsAddress, "metrics.address", "", "serve prometheus metrics on this address, e.g. :7979")
+	flag.StringVar(&config.AWSRegion, "aws.region", "us-west-2", "aws region of nodes without a zone in their ProviderID, nodes in other regions are looked up there")
 	flag.IntVar(&config.APIRetries, "aws.retries", 3, "aws api call retries")
 	flag.StringVar(&config.AWSAssumeRole, "aws.role", "", "aws assume role")
 	flag.StringVar(&config.AWSCredsFile, "aws.creds", "", "aws creds")
+	flag.StringVar(&config.AWSProfile, "aws.profile", "", "aws shared config profile, or profile of -aws.creds")
+	flag.StringVar(&config.AWSExternalID, "aws.external-id", "", "external id passed when assuming -aws.role")
+	flag.StringVar(&config.AWSSessionName, "aws.session-name", "tag-to-label", "session name of assumed roles")
+	flag.StringVar(&config.AWSWebIdentityTokenFile, "aws.web-identity-token-file", os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), "web identity token exchanged for credentials of -aws.web-identity-role")
+	flag.StringVar(&config.AWSWebIdentityRole, "aws.web-identity-role", os.Getenv("AWS_ROLE_ARN"), "role assumed with the web identity token")
+	flag.StringVar(&config.AWSEndpoint, "aws.endpoint", "", "ec2 endpoint, e.g. a local fake for testing")
+	flag.Float64Var(&config.AWSQPS, "aws.qps", 10, "aws calls per second shared by all regions and accounts, lowered while aws throttles, 0 disables the limit")
+	flag.IntVar(&config.AWSBurst, "aws.burst", 20, "aws calls allowed at once above -aws.qps")
+	flag.IntVar(&config.AWSDescribeTagsChunkSize, "aws.chunk-size", 200, "instance ids per DescribeTags call")
+	flag.IntVar(&config.AWSDescribeTagsConcurrency, "aws.concurrency", 4, "DescribeTags calls running at the same time")
+	flag.StringVar(&config.AWSAttributes, "aws.attributes", "", "comma separated attribute=label pairs, e.g. instance.lifecycle=lifecycle")
+	flag.StringVar(&config.AWSInstanceTypesFile, "aws.instance-types-file", "", "output of aws ec2 describe-instance-types used when the api is not available")
+	flag.BoolVar(&config.AWSNetworkTags, "aws.network-tags", false, "merge subnet and vpc tags with lower precedence than instance tags")
+	flag.StringVar(&config.AWSASGTags, "aws.asg-tags", "", "merge auto scaling group tags with lower or higher precedence than instance tags, empty disables it")
+	flag.StringVar(&config.AWSLaunchTemplateTags, "aws.launch-template-tags", "", "merge launch template tags with lower or higher precedence than instance tags, empty disables it")
+	flag.StringVar(&config.AWSEKSNodegroup, "aws.eks-nodegroup", "", "merge eks node group labels and tags with lower or higher precedence than instance tags, empty disables it")
+	flag.StringVar(&config.AWSEventQueueURL, "aws.event-queue", "", "url of an sqs queue of eventbridge tag change and instance state change events, empty polls only")
+	flag.StringVar(&config.AWSEventQueueEndpoint, "aws.event-queue-endpoint", "", "sqs endpoint, default the endpoint of -aws.region")
+	flag.StringVar(&config.AWSAccountRoles, "aws.account-roles", "", "comma separated roles assumed to look up instances of other accounts")
+	flag.StringVar(&config.AWSTagKeys, "aws.tag-keys", "", "comma separated tag key patterns fetched from ec2, default devops.apixio.com/*")
+	flag.StringVar(&config.GCEEndpoint, "gce.endpoint", "", "compute api endpoint, default https://compute.googleapis.com")
+	flag.StringVar(&config.GCEMetadataEndpoint, "gce.metadata-endpoint", "", "metadata server endpoint, default http://metadata.google.internal")
+	flag.StringVar(&config.GCEMetadataKeys, "gce.metadata-keys", "", "comma separated instance metadata keys exposed as tags")
+	flag.StringVar(&config.GCELabelPrefix, "gce.label-prefix", "", "only expose instance labels with this prefix")
+	flag.StringVar(&config.AzureEndpoint, "azure.endpoint", "", "arm api endpoint, default https://management.azure.com")
+	flag.StringVar(&config.AzureMetadataEndpoint, "azure.metadata-endpoint", "", "instance metadata endpoint, default http://169.254.169.254")
+	flag.StringVar(&config.AzureTagKeyPrefix, "azure.tag-prefix", "", "only expose vm tags with this prefix")
+	flag.StringVar(&config.StaticFile, "static.file", "", "yaml or json file mapping nodes to tags")
+	flag.DurationVar(&config.StaticInterval, "static.interval", 30*time.Second, "interval to check the static file for changes")
+	flag.StringVar(&config.ExecCommand, "exec.command", "", "plugin binary printing tags of a node")
+	flag.StringVar(&config.ExecArgs, "exec.args", "", "comma separated arguments of the plugin binary")
+	flag.DurationVar(&config.ExecTimeout, "exec.timeout", 10*time.Second, "timeout of a single plugin call")
+	flag.StringVar(&config.AnnotationPrefix, "annotation.prefix", "", "node annotations with this prefix are promoted to labels, default devops.apixio.com/")
+	flag.StringVar(&config.IMDSEndpoint, "imds.endpoint", "", "instance metadata endpoint, default http://169.254.169.254")
+	flag.StringVar(&config.IMDSTagPrefix, "imds.tag-prefix", "", "prefix of instance tags read as devops.apixio.com/ from metadata, which has no tags with '/' in their key, default devops.apixio.com:")
+	flag.StringVar(&config.RecordFile, "record.file", "", "append every tag lookup of the provider to this file, replay it with -provider=replay")
+	flag.StringVar(&config.ReplayFile, "replay.file", "", "file written with -record.file served by -provider=replay, labels are still written to the cluster unless -dry-run is set")
+	flag.StringVar(&config.ReplayAt, "replay.at", "", "replay tags as they were at this RFC3339 time, default the latest recorded")
+	flag.BoolVar(&config.DryRun, "dry-run", false, "log label changes instead of writing them to the cluster, e.g. with -provider=replay")
 }
@@ -4,12 +4,66 @@ import "time"
 
 type Config struct {
 	Master         string
+	NodeName       string
+	Provider       string
 	RequestTimeout time.Duration
 	AWSAssumeRole  string
 	AWSRegion      string
 	AWSVPCId       string
 	APIRetries     int
 
+	AWSProfile              string
+	AWSExternalID           string
+	AWSSessionName          string
+	AWSWebIdentityTokenFile string
+	AWSWebIdentityRole      string
+
+	CacheTTL         time.Duration
+	CacheNegativeTTL time.Duration
+	MetricsAddress   string
+
+	AWSEndpoint                string
+	AWSDescribeTagsChunkSize   int
+	AWSDescribeTagsConcurrency int
+	AWSTagKeys                 string
+	AWSAttributes              string
+	AWSInstanceTypesFile       string
+	AWSNetworkTags             bool
+	AWSASGTags                 string
+	AWSLaunchTemplateTags      string
+	AWSEKSNodegroup            string
+	AWSAccountRoles            string
+	AWSQPS                     float64
+	AWSBurst                   int
+	AWSEventQueueURL           string
+	AWSEventQueueEndpoint      string
+
+	GCEEndpoint         string
+	GCEMetadataEndpoint string
+	GCEMetadataKeys     string
+	GCELabelPrefix      string
+
+	AzureEndpoint         string
+	AzureMetadataEndpoint string
+	AzureTagKeyPrefix     string
+
+	StaticFile     string
+	StaticInterval time.Duration
+
+	ExecCommand string
+	ExecArgs    string
+	ExecTimeout time.Duration
+
+	AnnotationPrefix string
+
+	IMDSEndpoint  string
+	IMDSTagPrefix string
+
+	RecordFile string
+	ReplayFile string
+	ReplayAt   string
+	DryRun     bool
+
 	// Just use for testing purpse
 	AWSCredsFile   string
 	KubeConfig     string
@@ -3,13 +3,12 @@ package controller
 import (
 	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/zduymz/tag-to-label/pkg/apis/tag-to-label"
 	"github.com/zduymz/tag-to-label/pkg/provider"
-	"github.com/zduymz/tag-to-label/pkg/utils"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"reflect"
 	"strings"
 	"time"
 
@@ -37,23 +36,186 @@ type Controller struct {
 	kubeclientset kubernetes.Interface
 	hasSynced     cache.InformerSynced
 	workqueue     workqueue.RateLimitingInterface
-	provider      *provider.AWSProvider
+	provider      provider.Provider
+	// dryRun logs label changes instead of writing them
+	dryRun bool
 }
 
 func NewController(nodeInformer coreinformers.NodeInformer, podInformer coreinformers.PodInformer, kubeclientset kubernetes.Interface, config *tag_to_label.Config) (*Controller, error) {
-	klog.Info("Setting up AWS")
-
-	p, err := provider.NewAWSProvider(provider.AWSConfig{
-		Region:       config.AWSRegion,
-		AssumeRole:   config.AWSAssumeRole,
-		AWSCredsFile: config.AWSCredsFile,
-		APIRetries:   3,
-	})
+	p, err := newProvider(config)
+	if err == nil {
+		p, err = wrapProvider(p, config)
+	}
 	if err != nil {
 		klog.Errorf("Error: %s", err.Error())
 		return nil, err
 	}
 
+	controller := newController(nodeInformer, podInformer, kubeclientset, p)
+	if config.DryRun {
+		klog.Info("Dry run, label changes are only logged")
+		controller.dryRun = true
+	}
+	return controller, nil
+}
+
+// wrapProvider adds the cache and the recorder around a provider, the recorder goes outside
+// so lookups served from the cache are recorded as well
+func wrapProvider(p provider.Provider, config *tag_to_label.Config) (provider.Provider, error) {
+	// tags read from the node object are already in the informer cache
+	if config.CacheTTL > 0 && !p.Capabilities().FromNodeObject {
+		klog.Infof("Caching tags for %s", config.CacheTTL)
+		p = provider.NewCachedProvider(p, provider.CacheConfig{
+			TTL:         config.CacheTTL,
+			NegativeTTL: config.CacheNegativeTTL,
+		})
+	}
+
+	if config.RecordFile != "" {
+		return provider.NewRecordingProvider(p, provider.RecordConfig{File: config.RecordFile})
+	}
+	return p, nil
+}
+
+// newProvider builds the tag source selected by config.Provider
+func newProvider(config *tag_to_label.Config) (provider.Provider, error) {
+	switch config.Provider {
+	case "", "aws":
+		klog.Info("Setting up AWS")
+		attributes, err := splitMap(config.AWSAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -aws.attributes: %s", err.Error())
+		}
+		return provider.NewAWSProvider(provider.AWSConfig{
+			Region:       config.AWSRegion,
+			AssumeRole:   config.AWSAssumeRole,
+			AWSCredsFile: config.AWSCredsFile,
+			APIRetries:   config.APIRetries,
+
+			Profile:              config.AWSProfile,
+			ExternalID:           config.AWSExternalID,
+			SessionName:          config.AWSSessionName,
+			WebIdentityTokenFile: config.AWSWebIdentityTokenFile,
+			WebIdentityRole:      config.AWSWebIdentityRole,
+
+			Endpoint:                config.AWSEndpoint,
+			DescribeTagsChunkSize:   config.AWSDescribeTagsChunkSize,
+			DescribeTagsConcurrency: config.AWSDescribeTagsConcurrency,
+			TagKeys:                 awsTagKeys(config.AWSTagKeys),
+			TagPrefix:               TagNamePrefix,
+			Attributes:              attributes,
+			InstanceTypesFile:       config.AWSInstanceTypesFile,
+			NetworkTags:             config.AWSNetworkTags,
+			ASGTags:                 config.AWSASGTags,
+			LaunchTemplateTags:      config.AWSLaunchTemplateTags,
+			EKSNodegroup:            config.AWSEKSNodegroup,
+			AccountRoles:            splitList(config.AWSAccountRoles),
+			QPS:                     config.AWSQPS,
+			Burst:                   config.AWSBurst,
+			EventQueueURL:           config.AWSEventQueueURL,
+			EventQueueEndpoint:      config.AWSEventQueueEndpoint,
+		})
+	case "gce":
+		klog.Info("Setting up GCE")
+		return provider.NewGCEProvider(provider.GCEConfig{
+			Endpoint:         config.GCEEndpoint,
+			MetadataEndpoint: config.GCEMetadataEndpoint,
+			MetadataKeys:     splitList(config.GCEMetadataKeys),
+			LabelPrefix:      config.GCELabelPrefix,
+			TagPrefix:        TagNamePrefix,
+			Timeout:          config.RequestTimeout,
+		})
+	case "azure":
+		klog.Info("Setting up Azure")
+		return provider.NewAzureProvider(provider.AzureConfig{
+			Endpoint:         config.AzureEndpoint,
+			MetadataEndpoint: config.AzureMetadataEndpoint,
+			TagKeyPrefix:     config.AzureTagKeyPrefix,
+			TagPrefix:        TagNamePrefix,
+			Timeout:          config.RequestTimeout,
+		})
+	case "static":
+		klog.Info("Setting up static tags")
+		return provider.NewStaticProvider(provider.StaticConfig{
+			File:     config.StaticFile,
+			Interval: config.StaticInterval,
+		})
+	case "exec":
+		klog.Info("Setting up exec plugin")
+		return provider.NewExecProvider(provider.ExecConfig{
+			Command: config.ExecCommand,
+			Args:    splitList(config.ExecArgs),
+			Timeout: config.ExecTimeout,
+		})
+	case "annotation":
+		klog.Info("Setting up node annotations")
+		return provider.NewAnnotationProvider(provider.AnnotationConfig{
+			Prefix:    config.AnnotationPrefix,
+			TagPrefix: TagNamePrefix,
+		})
+	case "imds":
+		if config.NodeName == "" {
+			return nil, fmt.Errorf("imds provider needs -node-name, it only knows the instance it runs on")
+		}
+		klog.Infof("Setting up instance metadata of node %s", config.NodeName)
+		return provider.NewIMDSProvider(provider.IMDSConfig{
+			Endpoint:     config.IMDSEndpoint,
+			Timeout:      config.RequestTimeout,
+			TagKeyPrefix: config.IMDSTagPrefix,
+			TagPrefix:    TagNamePrefix,
+		})
+	case "replay":
+		var at time.Time
+		if config.ReplayAt != "" {
+			var err error
+			if at, err = time.Parse(time.RFC3339, config.ReplayAt); err != nil {
+				return nil, fmt.Errorf("invalid -replay.at: %s", err.Error())
+			}
+		}
+		klog.Info("Setting up replay of recorded lookups")
+		return provider.NewReplayProvider(provider.ReplayConfig{
+			File: config.ReplayFile,
+			At:   at,
+		})
+	default:
+		return nil, fmt.Errorf("unknown provider %q", config.Provider)
+	}
+}
+
+// awsTagKeys returns the key patterns EC2 filters tags by, by default everything under TagNamePrefix.
+// FilterTag still runs on the result, patterns outside the prefix would be dropped there.
+func awsTagKeys(patterns string) []string {
+	if keys := splitList(patterns); len(keys) > 0 {
+		return keys
+	}
+	return []string{TagNamePrefix + "*"}
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// splitMap parses a comma separated list of key=value pairs
+func splitMap(s string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, item := range splitList(s) {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || pair[0] == "" || pair[1] == "" {
+			return nil, fmt.Errorf("expected key=value but got %q", item)
+		}
+		result[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return result, nil
+}
+
+func newController(nodeInformer coreinformers.NodeInformer, podInformer coreinformers.PodInformer, kubeclientset kubernetes.Interface, p provider.Provider) *Controller {
 	controller := &Controller{
 		nodeLister:    nodeInformer.Lister(),
 		podLister:     podInformer.Lister(),
@@ -65,15 +227,19 @@ func NewController(nodeInformer coreinformers.NodeInformer, podInformer coreinfo
 
 	klog.Info("Setting up event handlers")
 
-	nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	nodeHandlers := cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.handleAddNodeObject,
-	})
+	}
+	if p.Capabilities().FromNodeObject {
+		nodeHandlers.UpdateFunc = controller.handleUpdateNodeObject
+	}
+	nodeInformer.Informer().AddEventHandler(nodeHandlers)
 
 	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: controller.handleAddPodObject,
 	})
 
-	return controller, nil
+	return controller
 }
 
 // Run will set event handler for pod, syncing informer caches and starting workers.
@@ -97,6 +263,11 @@ func (c *Controller) Run(stopCh <-chan struct{}) error {
 	go wait.Until(c.runNodeChecker, 5*time.Minute, stopCh)
 	klog.Info("[main] Started node checker ")
 
+	if watcher, ok := c.provider.(provider.Watcher); ok {
+		klog.Info("[main] Starting provider watcher")
+		go watcher.Watch(stopCh, c.enqueueMatchingNodes)
+	}
+
 	<-stopCh
 	klog.Info("[main] Shutting down worker and checker")
 	return nil
@@ -110,19 +281,24 @@ func (c *Controller) runNodeChecker() {
 		klog.Errorf("[runChecker] Failed to list nodes. Reason: %s", err.Error())
 		return
 	}
-	var instanceIds []*string
 	nodeNameById := map[string]string{}
+	var candidates []*corev1.Node
 	for _, no := range nodes {
-		// ProviderID: aws:///us-west-2c/i-08aab319ad2b55083
-		id, _ := utils.LastinSlice(strings.Split(no.Spec.ProviderID, "/"))
-		instanceIds = append(instanceIds, aws.String(id))
+		id, err := c.provider.InstanceID(no)
+		if err != nil {
+			klog.Warningf("[runChecker] Skip node [%s]. Reason: %s", no.GetName(), err.Error())
+			continue
+		}
+		candidates = append(candidates, no)
 		nodeNameById[id] = no.GetName()
 	}
 
 	klog.Info("[runChecker] Show all instances ids")
-	tagsById, err := c.provider.ListTags(instanceIds)
-	if err != nil {
-		klog.Errorf("[runChecker] Can not list aws tag. Reason: %s", err.Error())
+	tagsById, err := c.listTags(candidates)
+	if _, partial := err.(*provider.PartialError); partial {
+		klog.Errorf("[runChecker] Continue with the remaining nodes. Reason: %s", err.Error())
+	} else if err != nil {
+		klog.Errorf("[runChecker] Can not list tags. Reason: %s", err.Error())
 		return
 	}
 
@@ -145,6 +321,41 @@ func (c *Controller) runNodeChecker() {
 	}
 }
 
+// enqueueMatchingNodes queues every node selected by match for a label update
+func (c *Controller) enqueueMatchingNodes(match provider.NodeMatcher) {
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("[watcher] Failed to list nodes. Reason: %s", err.Error())
+		return
+	}
+	for _, no := range nodes {
+		if match(no) {
+			klog.Infof("[watcher] Tags of node [%s] changed", no.GetName())
+			c.workqueue.Add(fmt.Sprintf("node:%s", no.GetName()))
+		}
+	}
+}
+
+// listTags looks up tags of all nodes at once when the provider supports it,
+// otherwise node by node so one failing node does not hide the others.
+func (c *Controller) listTags(nodes []*corev1.Node) (map[string][]*provider.Tag, error) {
+	if c.provider.Capabilities().BatchLookup {
+		return c.provider.ListTags(nodes)
+	}
+	result := map[string][]*provider.Tag{}
+	for _, no := range nodes {
+		tags, err := c.provider.ListTags([]*corev1.Node{no})
+		if err != nil {
+			klog.Errorf("[runChecker] Can not list tags of node [%s]. Reason: %s", no.GetName(), err.Error())
+			continue
+		}
+		for id, t := range tags {
+			result[id] = t
+		}
+	}
+	return result, nil
+}
+
 func (c *Controller) runWorker() {
 	for c.processNextWorkItem() {
 	}
@@ -227,7 +438,7 @@ func (c *Controller) podHandler(name string) error {
 
 	no, err := c.nodeLister.Get(po.Spec.NodeName)
 	if err != nil {
-		klog.Warningf("Can not get node [%] info. Reason: %v", err)
+		klog.Warningf("Can not get node [%s] info. Reason: %v", po.Spec.NodeName, err)
 		return err
 	}
 
@@ -261,10 +472,14 @@ func (c *Controller) nodeHandler(name string) error {
 		return fmt.Errorf("node [%s] is not ready", no.GetName())
 	}
 
-	id, _ := utils.LastinSlice(strings.Split(no.Spec.ProviderID, "/"))
-	tags, err := c.provider.ListTags([]*string{aws.String(id)})
+	id, err := c.provider.InstanceID(no)
+	if err != nil {
+		klog.Warningf("[worker] Skip node [%s]. Reason: %s", no.GetName(), err.Error())
+		return nil
+	}
+	tags, err := c.provider.ListTags([]*corev1.Node{no})
 	if err != nil {
-		klog.Errorf("[worker] Can not list aws tag")
+		klog.Errorf("[worker] Can not list tags")
 		return err
 	}
 	klog.V(4).Info("[worker] Raw tags: ", tags)
@@ -289,6 +504,10 @@ func (c *Controller) nodeHandler(name string) error {
 //TODO: no idea why panic happen when calling this function with signature
 // func (c *Controller) updateNodeLabels(no *corev1.Node, newLabels map[string]string) error {
 func (c *Controller) updateNodeLabels(nodeName string, newLabels map[string]string) error {
+	if c.dryRun {
+		klog.Infof("[dry-run] Would update labels on node [%s]: %v", nodeName, newLabels)
+		return nil
+	}
 	no, _ := c.nodeLister.Get(nodeName)
 	nodeCopy := no.DeepCopy()
 	for k, v := range newLabels {
@@ -301,6 +520,10 @@ func (c *Controller) updateNodeLabels(nodeName string, newLabels map[string]stri
 }
 
 func (c *Controller) updatePodLabels(namespace, podName string, newLabels map[string]string) error {
+	if c.dryRun {
+		klog.Infof("[dry-run] Would update labels on pod [%s/%s]: %v", namespace, podName, newLabels)
+		return nil
+	}
 	po, err := c.podLister.Pods(namespace).Get(podName)
 	if err != nil {
 		klog.Warningf("pod %s is no longer exists", podName)
@@ -344,6 +567,25 @@ func (c *Controller) handleAddNodeObject(obj interface{}) {
 	}
 	c.workqueue.Add(fmt.Sprintf("node:%s", no.Name))
 }
+
+// handleUpdateNodeObject queues nodes whose annotations changed, label updates
+// done by the controller itself do not touch annotations.
+func (c *Controller) handleUpdateNodeObject(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	if reflect.DeepEqual(oldNode.Annotations, newNode.Annotations) {
+		return
+	}
+	klog.V(4).Infof("Annotations of node [%s] changed", newNode.GetName())
+	c.workqueue.Add(fmt.Sprintf("node:%s", newNode.GetName()))
+}
+
 func (c *Controller) handleAddPodObject(obj interface{}) {
 	var object metav1.Object
 	var ok bool
@@ -1,9 +1,23 @@
 package controller
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	tag_to_label "github.com/zduymz/tag-to-label/pkg/apis/tag-to-label"
 	"github.com/zduymz/tag-to-label/pkg/provider"
+	"github.com/zduymz/tag-to-label/pkg/provider/fake"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
 )
 
 func TestFilterTag(t *testing.T) {
@@ -58,3 +72,235 @@ func TestOuterRightJoin(t *testing.T) {
 	output, _ := OuterRightJoin(left, right)
 	assert.True(t, assert.ObjectsAreEqual(expect, output))
 }
+
+type fakeProvider struct {
+	tags  map[string][]*provider.Tag
+	calls int
+}
+
+func (f *fakeProvider) InstanceID(node *corev1.Node) (string, error) {
+	return node.GetName(), nil
+}
+
+func (f *fakeProvider) ListTags(nodes []*corev1.Node) (map[string][]*provider.Tag, error) {
+	f.calls++
+	result := map[string][]*provider.Tag{}
+	for _, no := range nodes {
+		result[no.GetName()] = f.tags[no.GetName()]
+	}
+	return result, nil
+}
+
+func (f *fakeProvider) Capabilities() provider.Capabilities {
+	return provider.Capabilities{}
+}
+
+func newReadyNode(name string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func newTestController(p provider.Provider, nodes ...*corev1.Node) (*Controller, *kubefake.Clientset) {
+	var objects []runtime.Object
+	for _, no := range nodes {
+		objects = append(objects, no)
+	}
+	client := kubefake.NewSimpleClientset(objects...)
+	factory := kubeinformers.NewSharedInformerFactory(client, 0)
+	nodeInformer := factory.Core().V1().Nodes()
+	for _, no := range nodes {
+		_ = nodeInformer.Informer().GetIndexer().Add(no)
+	}
+	return newController(nodeInformer, factory.Core().V1().Pods(), client, p), client
+}
+
+func TestNodeHandler(t *testing.T) {
+	p := &fakeProvider{tags: map[string][]*provider.Tag{
+		"node1": {
+			{Key: "devops.apixio.com/role", Value: "worker"},
+			{Key: "Name", Value: "ignored"},
+		},
+	}}
+	c, client := newTestController(p, newReadyNode("node1", map[string]string{"existing": "label"}))
+
+	assert.NoError(t, c.nodeHandler("node1"))
+
+	no, err := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"existing": "label", "role": "worker"}, no.Labels)
+}
+
+func TestRunNodeCheckerWithoutBatchLookup(t *testing.T) {
+	p := &fakeProvider{tags: map[string][]*provider.Tag{
+		"node1": {{Key: "devops.apixio.com/role", Value: "worker"}},
+		"node2": {{Key: "devops.apixio.com/role", Value: "infra"}},
+	}}
+	c, client := newTestController(p, newReadyNode("node1", map[string]string{}), newReadyNode("node2", map[string]string{}))
+
+	c.runNodeChecker()
+
+	assert.Equal(t, 2, p.calls)
+	for name, role := range map[string]string{"node1": "worker", "node2": "infra"} {
+		no, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, role, no.Labels["role"])
+	}
+}
+
+func TestAnnotationProvider(t *testing.T) {
+	p, _ := provider.NewAnnotationProvider(provider.AnnotationConfig{TagPrefix: TagNamePrefix})
+	no := newReadyNode("node1", map[string]string{})
+	no.Annotations = map[string]string{"devops.apixio.com/role": "worker", "other/annotation": "x"}
+	c, client := newTestController(p, no)
+
+	assert.NoError(t, c.nodeHandler("node1"))
+	updated, err := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"role": "worker"}, updated.Labels)
+
+	// only annotation changes are queued, not the label update done above
+	c.handleUpdateNodeObject(no, updated)
+	assert.Equal(t, 0, c.workqueue.Len())
+	changed := updated.DeepCopy()
+	changed.Annotations["devops.apixio.com/role"] = "infra"
+	c.handleUpdateNodeObject(updated, changed)
+	assert.Equal(t, 1, c.workqueue.Len())
+}
+
+// newFakeEC2Controller runs the aws provider against a fake EC2 api
+func newFakeEC2Controller(t *testing.T, ec2 *fake.EC2, nodes ...*corev1.Node) (*Controller, *kubefake.Clientset) {
+	setenv(t, "AWS_ACCESS_KEY_ID", "KEY")
+	setenv(t, "AWS_SECRET_ACCESS_KEY", "secret")
+	setenv(t, "AWS_EC2_METADATA_DISABLED", "true")
+	// a custom CA bundle can not be loaded into the instrumented transport
+	setenv(t, "AWS_CA_BUNDLE", "")
+	p, err := newProvider(&tag_to_label.Config{
+		Provider:                 "aws",
+		AWSRegion:                "us-west-2",
+		AWSEndpoint:              ec2.URL(),
+		APIRetries:               1,
+		AWSDescribeTagsChunkSize: 1,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return newTestController(p, nodes...)
+}
+
+// setenv sets an environment variable for the test, an empty value unsets it
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func newAWSNode(name, providerID string) *corev1.Node {
+	no := newReadyNode(name, map[string]string{})
+	no.Spec.ProviderID = providerID
+	return no
+}
+
+func nodeLabel(t *testing.T, client *kubefake.Clientset, name, key string) string {
+	no, err := client.CoreV1().Nodes().Get(context.Background(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	return no.Labels[key]
+}
+
+func TestReconcileFakeEC2(t *testing.T) {
+	ec2 := fake.NewEC2()
+	defer ec2.Close()
+	ec2.SetPageSize(1)
+	ec2.AddInstance(fake.Instance{ID: "i-1", Tags: map[string]string{"devops.apixio.com/role": "worker", "devops.apixio.com/pool": "blue", "Name": "node-1"}})
+	ec2.AddInstance(fake.Instance{ID: "i-2", Tags: map[string]string{"devops.apixio.com/role": "infra"}})
+	c, client := newFakeEC2Controller(t, ec2,
+		newAWSNode("node1", "aws:///us-west-2c/i-1"),
+		newAWSNode("node2", "aws:///us-west-2c/i-2"),
+	)
+
+	assert.NoError(t, c.nodeHandler("node1"))
+	no, err := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"role": "worker", "pool": "blue"}, no.Labels)
+
+	// a throttled call is retried
+	ec2.Throttle(1)
+	ec2.SetTags("i-1", map[string]string{"devops.apixio.com/role": "db"})
+	c.runNodeChecker()
+	assert.Equal(t, "db", nodeLabel(t, client, "node1", "role"))
+	assert.Equal(t, "infra", nodeLabel(t, client, "node2", "role"))
+}
+
+func TestReconcileFakeEC2PartialFailure(t *testing.T) {
+	ec2 := fake.NewEC2()
+	defer ec2.Close()
+	ec2.AddInstance(fake.Instance{ID: "i-1", Tags: map[string]string{"devops.apixio.com/role": "worker"}})
+	ec2.AddInstance(fake.Instance{ID: "i-2", Tags: map[string]string{"devops.apixio.com/role": "infra"}})
+	c, client := newFakeEC2Controller(t, ec2,
+		newAWSNode("node1", "aws:///us-west-2c/i-1"),
+		newAWSNode("node2", "aws:///us-west-2c/i-2"),
+	)
+
+	// one of the two chunks fails, the other node is still labelled
+	ec2.Fail("DescribeTags", "UnauthorizedOperation")
+	c.runNodeChecker()
+	labelled := 0
+	for _, name := range []string{"node1", "node2"} {
+		if nodeLabel(t, client, name, "role") != "" {
+			labelled++
+		}
+	}
+	assert.Equal(t, 1, labelled)
+
+	c.runNodeChecker()
+	assert.Equal(t, "worker", nodeLabel(t, client, "node1", "role"))
+	assert.Equal(t, "infra", nodeLabel(t, client, "node2", "role"))
+}
+
+func TestDryRun(t *testing.T) {
+	p := &fakeProvider{tags: map[string][]*provider.Tag{
+		"node1": {{Key: "devops.apixio.com/role", Value: "worker"}},
+	}}
+	c, client := newTestController(p, newReadyNode("node1", map[string]string{"existing": "label"}))
+	c.dryRun = true
+
+	assert.NoError(t, c.nodeHandler("node1"))
+	c.runNodeChecker()
+	no, err := client.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"existing": "label"}, no.Labels)
+}
+
+func TestRecordCacheHits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "lookups.json")
+
+	inner := &fakeProvider{tags: map[string][]*provider.Tag{"node1": {{Key: "devops.apixio.com/role", Value: "worker"}}}}
+	p, err := wrapProvider(inner, &tag_to_label.Config{CacheTTL: time.Minute, RecordFile: file})
+	assert.NoError(t, err)
+
+	nodes := []*corev1.Node{newReadyNode("node1", nil)}
+	for i := 0; i < 2; i++ {
+		_, err := p.ListTags(nodes)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, inner.calls, "the second lookup is served from the cache")
+	raw, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(raw), "\n"), "and recorded as well")
+}
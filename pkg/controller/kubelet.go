@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/zduymz/tag-to-label/pkg/provider"
+)
+
+// kubeletLabels are labels in the kubernetes.io and k8s.io namespaces a kubelet may set on its own node
+var kubeletLabels = map[string]bool{
+	"kubernetes.io/hostname":                   true,
+	"kubernetes.io/os":                         true,
+	"kubernetes.io/arch":                       true,
+	"beta.kubernetes.io/os":                    true,
+	"beta.kubernetes.io/arch":                  true,
+	"beta.kubernetes.io/instance-type":         true,
+	"node.kubernetes.io/instance-type":         true,
+	"failure-domain.beta.kubernetes.io/zone":   true,
+	"failure-domain.beta.kubernetes.io/region": true,
+	"topology.kubernetes.io/zone":              true,
+	"topology.kubernetes.io/region":            true,
+}
+
+// kubeletLabelNamespaces may be set by a kubelet, including their subdomains
+var kubeletLabelNamespaces = []string{"kubelet.kubernetes.io", "node.kubernetes.io"}
+
+// KubeletLabels turns tags of an instance into labels its kubelet can register the node with,
+// like TrimTag does for the controller. Labels the kubelet can not set are returned as rejected with a reason:
+// invalid keys or values, and kubernetes.io or k8s.io labels the NodeRestriction admission plugin refuses.
+func KubeletLabels(tags []*provider.Tag) (labels map[string]string, rejected []string) {
+	labels = map[string]string{}
+	for key, value := range TrimTag(tags) {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", key, strings.Join(errs, ", ")))
+			continue
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			rejected = append(rejected, fmt.Sprintf("%s=%s: %s", key, value, strings.Join(errs, ", ")))
+			continue
+		}
+		if !kubeletMaySet(key) {
+			rejected = append(rejected, fmt.Sprintf("%s: not allowed by NodeRestriction", key))
+			continue
+		}
+		labels[key] = value
+	}
+	sort.Strings(rejected)
+	return labels, rejected
+}
+
+// FormatNodeLabels formats labels like the kubelet --node-labels flag expects them, sorted by key
+func FormatNodeLabels(labels map[string]string) string {
+	var pairs []string
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func kubeletMaySet(key string) bool {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return true
+	}
+	namespace := parts[0]
+	if !inNamespace(namespace, "kubernetes.io") && !inNamespace(namespace, "k8s.io") {
+		return true
+	}
+	if kubeletLabels[key] {
+		return true
+	}
+	for _, allowed := range kubeletLabelNamespaces {
+		if inNamespace(namespace, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// inNamespace reports whether namespace is parent or one of its subdomains
+func inNamespace(namespace, parent string) bool {
+	return namespace == parent || strings.HasSuffix(namespace, "."+parent)
+}
@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zduymz/tag-to-label/pkg/provider"
+)
+
+func TestKubeletLabels(t *testing.T) {
+	labels, rejected := KubeletLabels([]*provider.Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com/team.example.com/owner", Value: "infra"},
+		{Key: "devops.apixio.com/node.kubernetes.io/pool", Value: "blue"},
+		{Key: "devops.apixio.com/topology.kubernetes.io/zone", Value: "us-west-2c"},
+		{Key: "devops.apixio.com/node-role.kubernetes.io/master", Value: ""},
+		{Key: "devops.apixio.com/node-restriction.kubernetes.io/trusted", Value: "true"},
+		{Key: "devops.apixio.com/cost center", Value: "1234"},
+		{Key: "devops.apixio.com/name", Value: "node 1"},
+		{Key: "Name", Value: "node-1"},
+	})
+	assert.Equal(t, map[string]string{
+		"role":                        "worker",
+		"team.example.com/owner":      "infra",
+		"node.kubernetes.io/pool":     "blue",
+		"topology.kubernetes.io/zone": "us-west-2c",
+	}, labels)
+	assert.Len(t, rejected, 4)
+	assert.Contains(t, rejected, "node-role.kubernetes.io/master: not allowed by NodeRestriction")
+	assert.Contains(t, rejected, "node-restriction.kubernetes.io/trusted: not allowed by NodeRestriction")
+
+	assert.Equal(t, "node.kubernetes.io/pool=blue,role=worker,team.example.com/owner=infra,topology.kubernetes.io/zone=us-west-2c", FormatNodeLabels(labels))
+}
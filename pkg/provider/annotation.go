@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// AnnotationConfig contains configuration to create a new annotation provider.
+type AnnotationConfig struct {
+	// Prefix selects the annotations treated as tags
+	Prefix string
+	// TagPrefix replaces Prefix in the tag keys
+	TagPrefix string
+}
+
+// AnnotationProvider promotes node annotations to tags, for tooling allowed to annotate but not to label nodes.
+type AnnotationProvider struct {
+	config AnnotationConfig
+}
+
+func NewAnnotationProvider(annotationConfig AnnotationConfig) (*AnnotationProvider, error) {
+	if annotationConfig.Prefix == "" {
+		annotationConfig.Prefix = annotationConfig.TagPrefix
+	}
+	klog.Infof("Using node annotations with prefix: %s", annotationConfig.Prefix)
+	return &AnnotationProvider{config: annotationConfig}, nil
+}
+
+// InstanceID returns the node name, annotations live on the node itself.
+func (p *AnnotationProvider) InstanceID(node *corev1.Node) (string, error) {
+	return node.GetName(), nil
+}
+
+func (p *AnnotationProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: true, FromNodeObject: true}
+}
+
+func (p *AnnotationProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	for _, no := range nodes {
+		result := make([]*Tag, 0)
+		for key, value := range no.GetAnnotations() {
+			if strings.HasPrefix(key, p.config.Prefix) {
+				key = p.config.TagPrefix + strings.TrimPrefix(key, p.config.Prefix)
+				result = append(result, &Tag{Key: key, Value: value})
+			}
+		}
+		tags[no.GetName()] = result
+	}
+	return tags, nil
+}
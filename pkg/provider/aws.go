@@ -1,21 +1,62 @@
 package provider
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
 	"github.com/linki/instrumented_http"
+	"github.com/zduymz/tag-to-label/pkg/utils"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog"
-	"strings"
 )
 
 type Ec2API interface {
 	DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error)
+	DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeLaunchTemplates(input *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error)
+	DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error)
+	DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error)
+	DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error)
+	DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error)
+	DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
 }
+
+const (
+	// EC2 accepts at most 200 values in a single filter
+	defaultDescribeTagsChunkSize   = 200
+	defaultDescribeTagsConcurrency = 4
+)
+
 type AWSProvider struct {
-	client Ec2API
+	client      Ec2API
+	chunkSize   int
+	concurrency int
+	tagKeys     []*string
+
+	// sources add candidate tags with lower (before) or higher (after) precedence than instance tags
+	before []awsSource
+	after  []awsSource
+
+	// region is where this provider looks up instances, nodes elsewhere go to providers made by newRegional
+	region      string
+	roles       []string
+	newRegional func(key awsRegionKey) (*AWSProvider, error)
+
+	lock     sync.Mutex
+	regional map[awsRegionKey]*AWSProvider
+	// accounts remembers the role instances were found with, see sweepAccounts
+	accounts      map[string]awsAccount
+	accountsSwept time.Time
+
+	// events tells which instances changed, nil polls only
+	events *eventQueue
 }
 
 // AWSConfig contains configuration to create a new AWS provider.
@@ -23,25 +64,54 @@ type AWSConfig struct {
 	Region     string
 	AssumeRole string
 	APIRetries int
+	// Endpoint overrides the EC2 endpoint of every region, e.g. to test against a local fake
+	Endpoint string
 
-	AWSCredsFile string
-}
+	// Profile selects the shared config profile, or the profile of AWSCredsFile
+	Profile string
+	// ExternalID is passed when assuming AssumeRole
+	ExternalID string
+	// SessionName names the sessions of AssumeRole and WebIdentityRole
+	SessionName string
+	// WebIdentityTokenFile is exchanged for credentials of WebIdentityRole, e.g. the token of IAM roles for service accounts
+	WebIdentityTokenFile string
+	WebIdentityRole      string
+
+	// DescribeTagsChunkSize is the number of instance ids per DescribeTags filter
+	DescribeTagsChunkSize int
+	// DescribeTagsConcurrency bounds the chunks fetched at the same time
+	DescribeTagsConcurrency int
+	// TagKeys are key patterns (* and ? wildcards) EC2 filters tags by, empty fetches every tag
+	TagKeys []string
+	// TagPrefix is prepended to label keys of mapped attributes so they pass the tag filter
+	TagPrefix string
+	// Attributes maps instance facts like instance.lifecycle to label keys
+	Attributes map[string]string
+	// InstanceTypesFile is a describe-instance-types snapshot used when the api is not available
+	InstanceTypesFile string
+	// NetworkTags merges tags of the subnet and VPC with the lowest precedence
+	NetworkTags bool
+	// ASGTags merges tags of the Auto Scaling group with "lower" or "higher" precedence, empty disables it
+	ASGTags string
+	// LaunchTemplateTags merges tags of the launch template with "lower" or "higher" precedence, empty disables it
+	LaunchTemplateTags string
+	// EKSNodegroup merges labels and tags of the EKS node group with "lower" or "higher" precedence, empty disables it
+	EKSNodegroup string
+	// QPS limits calls to AWS shared by all regions and accounts, Burst calls may go at once, 0 disables the limit
+	QPS   float64
+	Burst int
+	// EventQueueURL is an SQS queue of EventBridge events about instances, EventQueueEndpoint overrides the SQS endpoint
+	EventQueueURL      string
+	EventQueueEndpoint string
+	// AccountRoles are assumed to look up instances this account does not know, e.g. nodes launched in another account
+	AccountRoles []string
 
-type Tag struct {
-	Key   string
-	Value string
+	AWSCredsFile string
 }
 
 func NewAWSProvider(awsConfig AWSConfig) (*AWSProvider, error) {
 	config := aws.NewConfig().WithMaxRetries(awsConfig.APIRetries).WithRegion(awsConfig.Region)
 
-	// Only use for testing
-	if awsConfig.AWSCredsFile != "" {
-		klog.Warning("Not use aws credentials when running on production")
-
-		config.WithCredentials(credentials.NewSharedCredentials(awsConfig.AWSCredsFile, "default"))
-	}
-
 	config.WithHTTPClient(
 		instrumented_http.NewClient(config.HTTPClient, &instrumented_http.Callbacks{
 			PathProcessor: func(path string) string {
@@ -51,28 +121,259 @@ func NewAWSProvider(awsConfig AWSConfig) (*AWSProvider, error) {
 		}),
 	)
 
-	awsSession, err := session.NewSessionWithOptions(session.Options{
+	options := session.Options{
 		Config:            *config,
 		SharedConfigState: session.SharedConfigEnable,
-	})
+	}
+	// the profile of a credentials file is picked by awsCredentials, it may not exist in the shared config
+	if awsConfig.AWSCredsFile == "" {
+		options.Profile = awsConfig.Profile
+	}
+	awsSession, err := session.NewSessionWithOptions(options)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if awsConfig.AssumeRole != "" {
-		klog.Infof("Assuming role: %s", awsConfig.AssumeRole)
-		awsSession.Config.WithCredentials(stscreds.NewCredentials(awsSession, awsConfig.AssumeRole))
+	if awsConfig.QPS > 0 {
+		klog.Infof("Limiting aws calls to %.2f per second, burst %d", awsConfig.QPS, awsConfig.Burst)
+		// sessions of other regions and accounts are copies and share the limiter
+		newAdaptiveLimiter(awsConfig.QPS, awsConfig.Burst).install(&awsSession.Handlers)
+	}
+
+	if awsConfig.Endpoint != "" {
+		klog.Infof("Using ec2 endpoint: %s", awsConfig.Endpoint)
+	}
+
+	creds, source, err := awsCredentials(awsSession, awsConfig)
+	if err != nil {
+		return nil, err
+	}
+	awsSession.Config.WithCredentials(creds)
+	logCredentials(creds, source)
+
+	if len(awsConfig.TagKeys) > 0 {
+		klog.Infof("Only fetching tags matching: %s", strings.Join(awsConfig.TagKeys, ", "))
+	}
+	if awsConfig.NetworkTags {
+		klog.Info("Merging subnet and vpc tags")
+	}
+	for _, s := range []struct{ name, precedence string }{
+		{"auto scaling group tags", awsConfig.ASGTags},
+		{"launch template tags", awsConfig.LaunchTemplateTags},
+		{"eks node group labels and tags", awsConfig.EKSNodegroup},
+	} {
+		if s.precedence == "" {
+			continue
+		}
+		if err := validPrecedence(s.name, s.precedence); err != nil {
+			return nil, err
+		}
+		klog.Infof("Merging %s with %s precedence", s.name, s.precedence)
+	}
+
+	var snapshot map[string]*ec2.InstanceTypeInfo
+	if awsConfig.InstanceTypesFile != "" {
+		if snapshot, err = loadInstanceTypes(awsConfig.InstanceTypesFile); err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := newRegionalAWSProvider(awsSession, awsConfig, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	provider.region = awsConfig.Region
+	provider.roles = awsConfig.AccountRoles
+	provider.regional = map[awsRegionKey]*AWSProvider{}
+	provider.accounts = map[string]awsAccount{}
+	provider.newRegional = func(key awsRegionKey) (*AWSProvider, error) {
+		klog.Infof("Setting up aws region %s %s", key.region, key.role)
+		regionSession := awsSession.Copy(&aws.Config{Region: aws.String(key.region)})
+		if key.role != "" {
+			regionSession.Config.WithCredentials(assumeRole(regionSession, key.role, awsConfig))
+		}
+		return newRegionalAWSProvider(regionSession, awsConfig, snapshot)
+	}
+	if awsConfig.EventQueueURL != "" {
+		klog.Infof("Reading instance events from %s", awsConfig.EventQueueURL)
+		provider.events = newEventQueue(awsSession, awsConfig.EventQueueURL, awsConfig.EventQueueEndpoint)
+	}
+	if len(awsConfig.AccountRoles) > 0 {
+		klog.Infof("Looking up instances unknown to this account with: %s", strings.Join(awsConfig.AccountRoles, ", "))
+	}
+	return provider, nil
+}
+
+// newRegionalAWSProvider sets up the clients and sources of one region and account, settings are validated by NewAWSProvider
+func newRegionalAWSProvider(awsSession *session.Session, awsConfig AWSConfig, snapshot map[string]*ec2.InstanceTypeInfo) (*AWSProvider, error) {
+	endpoint := aws.NewConfig()
+	if awsConfig.Endpoint != "" {
+		endpoint.WithEndpoint(awsConfig.Endpoint)
 	}
+	client := ec2.New(awsSession, endpoint)
+	templates := &launchTemplates{client: client}
 
 	provider := &AWSProvider{
-		client: ec2.New(awsSession),
+		client:      client,
+		chunkSize:   awsConfig.DescribeTagsChunkSize,
+		concurrency: awsConfig.DescribeTagsConcurrency,
+		tagKeys:     aws.StringSlice(awsConfig.TagKeys),
+	}
+
+	// added first so every other source wins over network tags
+	if awsConfig.NetworkTags {
+		provider.addSource("lower", &networkSource{client: client})
+	}
+	if awsConfig.ASGTags != "" {
+		provider.addSource(awsConfig.ASGTags, &asgSource{client: autoscaling.New(awsSession)})
+	}
+	if awsConfig.LaunchTemplateTags != "" {
+		provider.addSource(awsConfig.LaunchTemplateTags, &launchTemplateSource{templates: templates})
+	}
+	if awsConfig.EKSNodegroup != "" {
+		provider.addSource(awsConfig.EKSNodegroup, &eksNodegroupSource{client: eks.New(awsSession), tagPrefix: awsConfig.TagPrefix})
+	}
+
+	if len(awsConfig.Attributes) > 0 {
+		attributes, err := newAttributeSource(awsConfig.TagPrefix, awsConfig.Attributes, map[string]attributeResolver{
+			"image":           imageAttributes{images: newImages(client)},
+			"instance":        instanceAttributes{},
+			"instance-type":   instanceTypeAttributes{types: newInstanceTypes(client, snapshot)},
+			"launch-template": launchTemplateAttributes{templates: templates},
+			"zone":            zoneAttributes{zones: &availabilityZones{client: client}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		provider.addSource("higher", attributes)
 	}
 
 	return provider, nil
 }
 
-func (p *AWSProvider) ListTags(instanceIds []*string) (map[string][]*Tag, error) {
+func (p *AWSProvider) addSource(precedence string, source awsSource) {
+	if precedence == "lower" {
+		p.before = append(p.before, source)
+	} else {
+		p.after = append(p.after, source)
+	}
+}
+
+// InstanceID returns the EC2 instance id of a node.
+func (p *AWSProvider) InstanceID(node *corev1.Node) (string, error) {
+	return awsInstanceID(node)
+}
+
+// awsInstanceID takes the instance id out of the ProviderID
+// ProviderID: aws:///us-west-2c/i-08aab319ad2b55083
+func awsInstanceID(node *corev1.Node) (string, error) {
+	if !strings.HasPrefix(node.Spec.ProviderID, "aws://") {
+		return "", fmt.Errorf("node [%s] has no aws ProviderID: %q", node.GetName(), node.Spec.ProviderID)
+	}
+	return utils.LastinSlice(strings.Split(node.Spec.ProviderID, "/"))
+}
+
+func (p *AWSProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: true}
+}
+
+// lookup fetches tags of instances in the region and account of this provider
+func (p *AWSProvider) lookup(instanceIds []*string) (map[string][]*Tag, error) {
+	tags, partial := p.describeTags(instanceIds)
+	if len(p.before) == 0 && len(p.after) == 0 {
+		return tags, partial.orNil()
+	}
+
+	instances, instancesPartial := p.describeInstances(instanceIds)
+	partial = partial.merge(instancesPartial)
+
+	before := listSourceTags(p.before, instances)
+	after := listSourceTags(p.after, instances)
+
+	// later tags win in TrimTag, so sources with lower precedence go first
+	result := make(map[string][]*Tag)
+	failed := partial.failedSet()
+	for _, instance := range instances {
+		id := aws.StringValue(instance.InstanceId)
+		if failed[id] {
+			continue
+		}
+		var merged []*Tag
+		for _, t := range before {
+			merged = append(merged, t[id]...)
+		}
+		merged = append(merged, tags[id]...)
+		for _, t := range after {
+			merged = append(merged, t[id]...)
+		}
+		if len(merged) > 0 {
+			result[id] = merged
+		}
+	}
+	return result, partial.orNil()
+}
+
+// inChunks calls fn concurrently for bounded chunks of ids, failed chunks are reported in a PartialError.
+func (p *AWSProvider) inChunks(what string, ids []*string, fn func(chunk []*string) error) *PartialError {
+	chunkSize := p.chunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultDescribeTagsChunkSize
+	}
+	concurrency := p.concurrency
+	if concurrency <= 0 {
+		concurrency = defaultDescribeTagsConcurrency
+	}
+
+	chunks := chunkStrings(ids, chunkSize)
+	errs := make([]error, len(chunks))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []*string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = fn(chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	var partial *PartialError
+	for i, chunk := range chunks {
+		if errs[i] == nil {
+			continue
+		}
+		klog.Errorf("Can not describe %s of %d instances. Reason: %s", what, len(chunk), errs[i].Error())
+		if partial == nil {
+			partial = &PartialError{Err: errs[i]}
+		}
+		partial.Failed = append(partial.Failed, aws.StringValueSlice(chunk)...)
+	}
+	return partial
+}
+
+// describeTags fetches tags of instances in chunks, a failed chunk does not drop the tags of the others.
+func (p *AWSProvider) describeTags(instanceIds []*string) (map[string][]*Tag, *PartialError) {
+	tags := make(map[string][]*Tag)
+	var lock sync.Mutex
+	partial := p.inChunks("tags", instanceIds, func(chunk []*string) error {
+		t, err := p.describeTagsChunk(chunk)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		for id, instanceTags := range t {
+			tags[id] = instanceTags
+		}
+		return nil
+	})
+	return tags, partial
+}
+
+func (p *AWSProvider) describeTagsChunk(instanceIds []*string) (map[string][]*Tag, error) {
 	tags := make(map[string][]*Tag)
 
 	describeTagsInput := &ec2.DescribeTagsInput{
@@ -83,6 +384,12 @@ func (p *AWSProvider) ListTags(instanceIds []*string) (map[string][]*Tag, error)
 			},
 		},
 	}
+	if len(p.tagKeys) > 0 {
+		describeTagsInput.Filters = append(describeTagsInput.Filters, &ec2.Filter{
+			Name:   aws.String("key"),
+			Values: p.tagKeys,
+		})
+	}
 
 	for {
 		describeTagsOutput, err := p.client.DescribeTags(describeTagsInput)
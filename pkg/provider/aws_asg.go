@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	asgGroupNameTag = "aws:autoscaling:groupName"
+	// DescribeAutoScalingInstances accepts at most 50 instance ids
+	asgInstancesChunkSize = 50
+	// DescribeTags accepts at most 1000 values per filter
+	asgGroupsChunkSize = 1000
+)
+
+type AutoScalingAPI interface {
+	DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error)
+	DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error)
+}
+
+// asgSource adds the tags of the Auto Scaling group an instance belongs to,
+// including tags with PropagateAtLaunch=false which never reach the instance.
+type asgSource struct {
+	client AutoScalingAPI
+}
+
+// validPrecedence checks a precedence setting of a source: "lower" or "higher" than instance tags
+func validPrecedence(name, precedence string) error {
+	if precedence != "lower" && precedence != "higher" {
+		return fmt.Errorf("%s precedence must be lower or higher, got %q", name, precedence)
+	}
+	return nil
+}
+
+func (s *asgSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	groupById, err := s.groupNames(instances)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*string
+	seen := map[string]bool{}
+	for _, group := range groupById {
+		if !seen[group] {
+			seen[group] = true
+			groups = append(groups, aws.String(group))
+		}
+	}
+
+	groupTags := map[string][]*Tag{}
+	for _, chunk := range chunkStrings(groups, asgGroupsChunkSize) {
+		input := &autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{Name: aws.String("auto-scaling-group"), Values: chunk},
+			},
+		}
+		for {
+			output, err := s.client.DescribeTags(input)
+			if err != nil {
+				return nil, fmt.Errorf("can not describe auto scaling group tags: %s", err.Error())
+			}
+			for _, tag := range output.Tags {
+				group := aws.StringValue(tag.ResourceId)
+				groupTags[group] = append(groupTags[group], &Tag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+
+	tags := make(map[string][]*Tag)
+	for id, group := range groupById {
+		if t, ok := groupTags[group]; ok {
+			tags[id] = t
+		}
+	}
+	return tags, nil
+}
+
+// groupNames resolves instances to their group through the aws:autoscaling:groupName tag,
+// instances without it are looked up in the autoscaling api.
+func (s *asgSource) groupNames(instances []*ec2.Instance) (map[string]string, error) {
+	groupById := map[string]string{}
+	var unknown []*string
+	for _, instance := range instances {
+		id := aws.StringValue(instance.InstanceId)
+		if group := ec2TagValue(instance.Tags, asgGroupNameTag); group != "" {
+			groupById[id] = group
+		} else {
+			unknown = append(unknown, instance.InstanceId)
+		}
+	}
+
+	for _, chunk := range chunkStrings(unknown, asgInstancesChunkSize) {
+		input := &autoscaling.DescribeAutoScalingInstancesInput{InstanceIds: chunk}
+		for {
+			output, err := s.client.DescribeAutoScalingInstances(input)
+			if err != nil {
+				return nil, fmt.Errorf("can not describe auto scaling instances: %s", err.Error())
+			}
+			for _, instance := range output.AutoScalingInstances {
+				groupById[aws.StringValue(instance.InstanceId)] = aws.StringValue(instance.AutoScalingGroupName)
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return groupById, nil
+}
+
+func ec2TagValue(tags []*ec2.Tag, key string) string {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+func chunkStrings(values []*string, size int) [][]*string {
+	var chunks [][]*string
+	for len(values) > size {
+		chunks = append(chunks, values[:size])
+		values = values[size:]
+	}
+	if len(values) > 0 {
+		chunks = append(chunks, values)
+	}
+	return chunks
+}
@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type fakeAutoScaling struct {
+	groups      map[string]string
+	tags        map[string]map[string]string
+	lookedUpIds []string
+}
+
+func (f *fakeAutoScaling) DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error) {
+	out := &autoscaling.DescribeTagsOutput{}
+	for _, group := range aws.StringValueSlice(input.Filters[0].Values) {
+		for k, v := range f.tags[group] {
+			out.Tags = append(out.Tags, &autoscaling.TagDescription{ResourceId: aws.String(group), Key: aws.String(k), Value: aws.String(v)})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeAutoScaling) DescribeAutoScalingInstances(input *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	out := &autoscaling.DescribeAutoScalingInstancesOutput{}
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		f.lookedUpIds = append(f.lookedUpIds, id)
+		if group, ok := f.groups[id]; ok {
+			out.AutoScalingInstances = append(out.AutoScalingInstances, &autoscaling.InstanceDetails{InstanceId: aws.String(id), AutoScalingGroupName: aws.String(group)})
+		}
+	}
+	return out, nil
+}
+
+func TestASGSource(t *testing.T) {
+	client := &instancesEc2{
+		tags: map[string][]*ec2.TagDescription{
+			"i-1": {{ResourceId: aws.String("i-1"), Key: aws.String("devops.apixio.com/role"), Value: aws.String("instance")}},
+		},
+		instances: map[string]*ec2.Instance{
+			"i-1": {InstanceId: aws.String("i-1"), Tags: []*ec2.Tag{{Key: aws.String(asgGroupNameTag), Value: aws.String("workers")}}},
+			"i-2": {InstanceId: aws.String("i-2")},
+			"i-3": {InstanceId: aws.String("i-3")},
+		},
+	}
+	asg := &fakeAutoScaling{
+		groups: map[string]string{"i-2": "infra"},
+		tags: map[string]map[string]string{
+			"workers": {"devops.apixio.com/role": "group", "devops.apixio.com/pool": "workers"},
+			"infra":   {"devops.apixio.com/pool": "infra"},
+		},
+	}
+	nodes := []*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+		newNode("node3", "aws:///us-west-2c/i-3"),
+	}
+
+	p := &AWSProvider{client: client}
+	p.addSource("lower", &asgSource{client: asg})
+	tags, err := p.ListTags(nodes)
+	assert.NoError(t, err)
+	// only instances without the group name tag are looked up
+	assert.Equal(t, []string{"i-2", "i-3"}, asg.lookedUpIds)
+	assert.Equal(t, "instance", labelValue(tags["i-1"], "devops.apixio.com/role"))
+	assert.Equal(t, "workers", labelValue(tags["i-1"], "devops.apixio.com/pool"))
+	assert.Equal(t, []*Tag{{Key: "devops.apixio.com/pool", Value: "infra"}}, tags["i-2"])
+	assert.NotContains(t, tags, "i-3")
+
+	p = &AWSProvider{client: client}
+	p.addSource("higher", &asgSource{client: asg})
+	tags, err = p.ListTags(nodes[:1])
+	assert.NoError(t, err)
+	assert.Equal(t, "group", labelValue(tags["i-1"], "devops.apixio.com/role"))
+}
+
+// labelValue returns the value a key ends up with after merging, later tags win
+func labelValue(tags []*Tag, key string) string {
+	value := ""
+	for _, tag := range tags {
+		if tag.Key == key {
+			value = tag.Value
+		}
+	}
+	return value
+}
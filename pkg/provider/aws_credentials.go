@@ -0,0 +1,69 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"k8s.io/klog"
+)
+
+// awsCredentials returns the credentials requests are signed with and where they come from.
+// Without any setting the session's default chain is used: environment, shared config,
+// container credentials (ECS, EKS Pod Identity), web identity from AWS_* variables and the instance role.
+func awsCredentials(awsSession *session.Session, awsConfig AWSConfig) (*credentials.Credentials, string, error) {
+	creds := awsSession.Config.Credentials
+	source := "default chain"
+	if awsConfig.Profile != "" {
+		source = fmt.Sprintf("profile %s", awsConfig.Profile)
+	}
+
+	// Only use for testing
+	if awsConfig.AWSCredsFile != "" {
+		klog.Warning("Not use aws credentials when running on production")
+		profile := awsConfig.Profile
+		if profile == "" {
+			profile = "default"
+		}
+		creds = credentials.NewSharedCredentials(awsConfig.AWSCredsFile, profile)
+		source = fmt.Sprintf("credentials file %s, profile %s", awsConfig.AWSCredsFile, profile)
+	}
+
+	if awsConfig.WebIdentityTokenFile != "" {
+		if awsConfig.WebIdentityRole == "" {
+			return nil, "", fmt.Errorf("web identity token file %s needs a role", awsConfig.WebIdentityTokenFile)
+		}
+		creds = stscreds.NewWebIdentityCredentials(awsSession, awsConfig.WebIdentityRole, awsConfig.SessionName, awsConfig.WebIdentityTokenFile)
+		source = fmt.Sprintf("web identity token %s, role %s", awsConfig.WebIdentityTokenFile, awsConfig.WebIdentityRole)
+	}
+
+	if awsConfig.AssumeRole != "" {
+		creds = assumeRole(awsSession.Copy(&aws.Config{Credentials: creds}), awsConfig.AssumeRole, awsConfig)
+		source = fmt.Sprintf("%s, assuming role %s", source, awsConfig.AssumeRole)
+	}
+	return creds, source, nil
+}
+
+// assumeRole returns credentials of role assumed with the credentials of awsSession
+func assumeRole(awsSession *session.Session, role string, awsConfig AWSConfig) *credentials.Credentials {
+	return stscreds.NewCredentials(awsSession, role, func(p *stscreds.AssumeRoleProvider) {
+		if awsConfig.ExternalID != "" {
+			p.ExternalID = aws.String(awsConfig.ExternalID)
+		}
+		if awsConfig.SessionName != "" {
+			p.RoleSessionName = awsConfig.SessionName
+		}
+	})
+}
+
+// logCredentials resolves credentials once so a misconfiguration shows up at startup rather than on the first sync
+func logCredentials(creds *credentials.Credentials, source string) {
+	value, err := creds.Get()
+	if err != nil {
+		klog.Warningf("Can not get aws credentials from %s: %s", source, err.Error())
+		return
+	}
+	klog.Infof("Using aws credentials from %s (%s)", source, value.ProviderName)
+}
@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASSUMED</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func TestAWSCredentialsProfile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "aws-credentials")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials")
+	writeFile(t, file, "[default]\naws_access_key_id = DEFAULT\naws_secret_access_key = secret\n\n[ci]\naws_access_key_id = CI\naws_secret_access_key = secret\n")
+
+	awsSession := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	creds, source, err := awsCredentials(awsSession, AWSConfig{AWSCredsFile: file, Profile: "ci"})
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("credentials file %s, profile ci", file), source)
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "CI", value.AccessKeyID)
+}
+
+func TestAWSCredentialsAssumeRole(t *testing.T) {
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{}
+		for k := range r.PostForm {
+			form[k] = r.PostForm.Get(k)
+		}
+		fmt.Fprintf(w, assumeRoleResponse, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("BASE", "secret", ""),
+	}))
+	creds, source, err := awsCredentials(awsSession, AWSConfig{
+		AssumeRole:  "arn:aws:iam::123456789012:role/tag-to-label",
+		ExternalID:  "cluster-1",
+		SessionName: "tag-to-label",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "default chain, assuming role arn:aws:iam::123456789012:role/tag-to-label", source)
+
+	value, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "ASSUMED", value.AccessKeyID)
+	assert.Equal(t, "AssumeRole", form["Action"])
+	assert.Equal(t, "cluster-1", form["ExternalId"])
+	assert.Equal(t, "tag-to-label", form["RoleSessionName"])
+}
+
+func TestAWSCredentialsWebIdentityNeedsRole(t *testing.T) {
+	awsSession := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-west-2")}))
+	_, _, err := awsCredentials(awsSession, AWSConfig{WebIdentityTokenFile: "/var/run/secrets/eks.amazonaws.com/serviceaccount/token"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+)
+
+const (
+	eksClusterNameTag   = "eks:cluster-name"
+	eksNodegroupNameTag = "eks:nodegroup-name"
+)
+
+type EKSAPI interface {
+	DescribeNodegroup(input *eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error)
+}
+
+// eksNodegroupSource adds labels and tags declared on the EKS managed node group of an instance,
+// so nodes created before a node group update converge to the new labels. Declared taints are not
+// applied, the controller only manages labels.
+type eksNodegroupSource struct {
+	client EKSAPI
+	// tagPrefix is prepended to declared labels, they are label keys already and must pass the tag filter as is
+	tagPrefix string
+}
+
+type eksNodegroupKey struct {
+	cluster   string
+	nodegroup string
+}
+
+func (s *eksNodegroupSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	nodegroups := map[eksNodegroupKey][]*Tag{}
+	tags := make(map[string][]*Tag)
+	for _, instance := range instances {
+		key := eksNodegroupKey{
+			cluster:   ec2TagValue(instance.Tags, eksClusterNameTag),
+			nodegroup: ec2TagValue(instance.Tags, eksNodegroupNameTag),
+		}
+		if key.cluster == "" || key.nodegroup == "" {
+			continue
+		}
+
+		nodegroupTags, ok := nodegroups[key]
+		if !ok {
+			var err error
+			if nodegroupTags, err = s.describe(key); err != nil {
+				return nil, err
+			}
+			nodegroups[key] = nodegroupTags
+		}
+		if len(nodegroupTags) > 0 {
+			tags[aws.StringValue(instance.InstanceId)] = nodegroupTags
+		}
+	}
+	return tags, nil
+}
+
+// describe returns the tags of a node group followed by its labels, labels win
+func (s *eksNodegroupSource) describe(key eksNodegroupKey) ([]*Tag, error) {
+	output, err := s.client.DescribeNodegroup(&eks.DescribeNodegroupInput{
+		ClusterName:   aws.String(key.cluster),
+		NodegroupName: aws.String(key.nodegroup),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not describe node group %s/%s: %s", key.cluster, key.nodegroup, err.Error())
+	}
+
+	var tags []*Tag
+	for k, v := range output.Nodegroup.Tags {
+		tags = append(tags, &Tag{Key: k, Value: aws.StringValue(v)})
+	}
+	for k, v := range output.Nodegroup.Labels {
+		tags = append(tags, &Tag{Key: s.tagPrefix + k, Value: aws.StringValue(v)})
+	}
+	return tags, nil
+}
@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEKS struct {
+	nodegroups map[string]*eks.Nodegroup
+	calls      int
+}
+
+func (f *fakeEKS) DescribeNodegroup(input *eks.DescribeNodegroupInput) (*eks.DescribeNodegroupOutput, error) {
+	f.calls++
+	return &eks.DescribeNodegroupOutput{Nodegroup: f.nodegroups[aws.StringValue(input.ClusterName)+"/"+aws.StringValue(input.NodegroupName)]}, nil
+}
+
+func inNodegroup(id, cluster, nodegroup string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String(id),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(eksClusterNameTag), Value: aws.String(cluster)},
+			{Key: aws.String(eksNodegroupNameTag), Value: aws.String(nodegroup)},
+		},
+	}
+}
+
+func TestEKSNodegroupSource(t *testing.T) {
+	client := &fakeEKS{nodegroups: map[string]*eks.Nodegroup{
+		"prod/workers": {
+			Labels: aws.StringMap(map[string]string{"role": "worker"}),
+			Tags:   aws.StringMap(map[string]string{"devops.apixio.com/team": "infra"}),
+		},
+	}}
+	source := &eksNodegroupSource{client: client, tagPrefix: "devops.apixio.com/"}
+
+	tags, err := source.listTags([]*ec2.Instance{
+		inNodegroup("i-1", "prod", "workers"),
+		inNodegroup("i-2", "prod", "workers"),
+		{InstanceId: aws.String("i-3")},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.calls)
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/team", Value: "infra"},
+		{Key: "devops.apixio.com/role", Value: "worker"},
+	}, tags["i-1"])
+	assert.Equal(t, tags["i-1"], tags["i-2"])
+	assert.NotContains(t, tags, "i-3")
+}
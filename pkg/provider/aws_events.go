@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const (
+	// SQS waits at most 20 seconds for messages
+	eventQueueWaitSeconds = 20
+	eventQueueBatchSize   = 10
+	// eventQueueRetryDelay is the pause after the queue failed
+	eventQueueRetryDelay = 5 * time.Second
+)
+
+type SQSAPI interface {
+	ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error)
+}
+
+// eventQueue reads EventBridge events about instances from SQS, e.g. "Tag Change on Resource"
+// and "EC2 Instance State-change Notification", so changes show up without waiting for the checker.
+type eventQueue struct {
+	client SQSAPI
+	url    string
+}
+
+// newEventQueue reads the queue at url, endpoint overrides the SQS endpoint
+func newEventQueue(awsSession *session.Session, url, endpoint string) *eventQueue {
+	config := aws.NewConfig()
+	if endpoint != "" {
+		config.WithEndpoint(endpoint)
+	}
+	return &eventQueue{client: sqs.New(awsSession, config), url: url}
+}
+
+// instanceEvent is the part of an EventBridge event naming the instances it is about
+type instanceEvent struct {
+	DetailType string   `json:"detail-type"`
+	Resources  []string `json:"resources"`
+	Detail     struct {
+		InstanceID string `json:"instance-id"`
+	} `json:"detail"`
+}
+
+// instanceIds returns ids of instance ARNs like arn:aws:ec2:us-west-2:123456789012:instance/i-1 and of the detail
+func (e *instanceEvent) instanceIds() []string {
+	var ids []string
+	for _, arn := range e.Resources {
+		if i := strings.Index(arn, ":instance/"); i >= 0 {
+			ids = append(ids, arn[i+len(":instance/"):])
+		}
+	}
+	if e.Detail.InstanceID != "" {
+		ids = append(ids, e.Detail.InstanceID)
+	}
+	return ids
+}
+
+// receive waits for a batch of events and returns the instances they are about, handled messages are deleted
+func (q *eventQueue) receive(ctx context.Context) (map[string]bool, error) {
+	output, err := q.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(eventQueueBatchSize),
+		WaitTimeSeconds:     aws.Int64(eventQueueWaitSeconds),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{}
+	for _, message := range output.Messages {
+		event := &instanceEvent{}
+		if err := json.Unmarshal([]byte(aws.StringValue(message.Body)), event); err != nil {
+			// deleted anyway, it would fail the same way every time
+			klog.Warningf("[events] Dropping message %s. Reason: %s", aws.StringValue(message.MessageId), err.Error())
+		}
+		for _, id := range event.instanceIds() {
+			klog.V(2).Infof("[events] %s of %s", event.DetailType, id)
+			ids[id] = true
+		}
+		if _, err := q.client.DeleteMessage(&sqs.DeleteMessageInput{
+			QueueUrl:      aws.String(q.url),
+			ReceiptHandle: message.ReceiptHandle,
+		}); err != nil {
+			klog.Warningf("[events] Can not delete message %s. Reason: %s", aws.StringValue(message.MessageId), err.Error())
+		}
+	}
+	return ids, nil
+}
+
+// Watch reports nodes of instances named by events of the queue, it only returns when stopCh is closed.
+func (p *AWSProvider) Watch(stopCh <-chan struct{}, changed func(NodeMatcher)) {
+	if p.events == nil {
+		<-stopCh
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	wait.Until(func() {
+		for ctx.Err() == nil {
+			ids, err := p.events.receive(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					klog.Errorf("[events] Can not receive events from %s. Reason: %s", p.events.url, err.Error())
+				}
+				return
+			}
+			if len(ids) == 0 {
+				continue
+			}
+			changed(func(node *corev1.Node) bool {
+				id, err := p.InstanceID(node)
+				return err == nil && ids[id]
+			})
+		}
+	}, eventQueueRetryDelay, stopCh)
+}
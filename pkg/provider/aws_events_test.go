@@ -0,0 +1,112 @@
+package provider
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeSQS serves queued message bodies through the SQS query api
+type fakeSQS struct {
+	lock     sync.Mutex
+	messages []string
+	deleted  []string
+}
+
+func (f *fakeSQS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	switch r.PostForm.Get("Action") {
+	case "ReceiveMessage":
+		var messages strings.Builder
+		for i, body := range f.messages {
+			sum := md5.Sum([]byte(body))
+			escaped := &strings.Builder{}
+			xml.EscapeText(escaped, []byte(body))
+			fmt.Fprintf(&messages, "<Message><MessageId>%d</MessageId><ReceiptHandle>receipt-%d</ReceiptHandle><MD5OfBody>%s</MD5OfBody><Body>%s</Body></Message>",
+				i, i, hex.EncodeToString(sum[:]), escaped.String())
+		}
+		if len(f.messages) == 0 {
+			// a short long poll
+			time.Sleep(10 * time.Millisecond)
+		}
+		f.messages = nil
+		fmt.Fprintf(w, "<ReceiveMessageResponse><ReceiveMessageResult>%s</ReceiveMessageResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></ReceiveMessageResponse>", messages.String())
+	case "DeleteMessage":
+		f.deleted = append(f.deleted, r.PostForm.Get("ReceiptHandle"))
+		fmt.Fprint(w, "<DeleteMessageResponse><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></DeleteMessageResponse>")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestAWSWatchEvents(t *testing.T) {
+	queue := &fakeSQS{messages: []string{
+		`{"detail-type":"Tag Change on Resource","source":"aws.tag","resources":["arn:aws:ec2:us-west-2:123456789012:instance/i-1"],"detail":{"changed-tag-keys":["devops.apixio.com/role"],"service":"ec2","resource-type":"instance"}}`,
+		`{"detail-type":"EC2 Instance State-change Notification","source":"aws.ec2","resources":["arn:aws:ec2:us-west-2:123456789012:instance/i-3"],"detail":{"instance-id":"i-3","state":"running"}}`,
+		`not an event`,
+	}}
+	server := httptest.NewServer(queue)
+	defer server.Close()
+
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Credentials: credentials.NewStaticCredentials("KEY", "secret", ""),
+	}))
+	p := &AWSProvider{events: newEventQueue(awsSession, server.URL+"/123456789012/events", server.URL)}
+
+	nodes := []*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+		newNode("node3", "aws:///us-west-2c/i-3"),
+	}
+	matched := make(chan []string, 1)
+	stopCh := make(chan struct{})
+	go p.Watch(stopCh, func(match NodeMatcher) {
+		var names []string
+		for _, no := range nodes {
+			if match(no) {
+				names = append(names, no.GetName())
+			}
+		}
+		matched <- names
+	})
+
+	select {
+	case names := <-matched:
+		assert.ElementsMatch(t, []string{"node1", "node3"}, names)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change reported")
+	}
+	close(stopCh)
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	assert.Equal(t, []string{"receipt-0", "receipt-1", "receipt-2"}, queue.deleted)
+}
+
+func TestAWSWatchWithoutQueue(t *testing.T) {
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		(&AWSProvider{}).Watch(stopCh, func(NodeMatcher) { t.Error("no queue, no changes") })
+		close(done)
+	}()
+	close(stopCh)
+	<-done
+}
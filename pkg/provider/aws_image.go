@@ -0,0 +1,137 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// images looks up the AMIs instances booted from, an AMI does not change once registered so results are kept forever.
+type images struct {
+	client Ec2API
+
+	lock sync.Mutex
+	// cache keeps nil for images that are gone, so they are not looked up again
+	cache map[string]*ec2.Image
+}
+
+func newImages(client Ec2API) *images {
+	return &images{
+		client: client,
+		cache:  map[string]*ec2.Image{},
+	}
+}
+
+// describe returns images by id, deregistered images are left out
+func (i *images) describe(ids []string) (map[string]*ec2.Image, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	var missing []*string
+	for _, id := range ids {
+		if _, ok := i.cache[id]; !ok && id != "" {
+			i.cache[id] = nil
+			missing = append(missing, aws.String(id))
+		}
+	}
+
+	for _, chunk := range chunkStrings(missing, defaultDescribeTagsChunkSize) {
+		err := i.describeIds(chunk)
+		if isImageNotFound(err) {
+			// one unknown id fails the whole call, retry one by one to skip deregistered images
+			for _, id := range chunk {
+				if err := i.describeIds([]*string{id}); err != nil && !isImageNotFound(err) {
+					i.forget(missing)
+					return nil, fmt.Errorf("can not describe images: %s", err.Error())
+				}
+			}
+			continue
+		}
+		if err != nil {
+			i.forget(missing)
+			return nil, fmt.Errorf("can not describe images: %s", err.Error())
+		}
+	}
+
+	result := map[string]*ec2.Image{}
+	for _, id := range ids {
+		if image := i.cache[id]; image != nil {
+			result[id] = image
+		}
+	}
+	return result, nil
+}
+
+// describeIds fetches images into the cache, callers hold the lock
+func (i *images) describeIds(ids []*string) error {
+	input := &ec2.DescribeImagesInput{ImageIds: ids}
+	for {
+		output, err := i.client.DescribeImages(input)
+		if err != nil {
+			return err
+		}
+		for _, image := range output.Images {
+			i.cache[aws.StringValue(image.ImageId)] = image
+		}
+		if output.NextToken == nil {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// forget drops images that were not looked up because of an error, callers hold the lock
+func (i *images) forget(ids []*string) {
+	for _, id := range aws.StringValueSlice(ids) {
+		if i.cache[id] == nil {
+			delete(i.cache, id)
+		}
+	}
+}
+
+func isImageNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "InvalidAMIID.NotFound" || aerr.Code() == "InvalidAMIID.Unavailable")
+}
+
+// imageAttributes exposes the AMI an instance booted from, so nodes still on an old image can be selected.
+type imageAttributes struct {
+	images *images
+}
+
+func (imageAttributes) names() []string {
+	return []string{"name", "creation-date", "tag.*"}
+}
+
+func (a imageAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	var ids []string
+	for _, instance := range instances {
+		ids = append(ids, aws.StringValue(instance.ImageId))
+	}
+	images, err := a.images.describe(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]map[string]string{}
+	for _, instance := range instances {
+		image, ok := images[aws.StringValue(instance.ImageId)]
+		if !ok {
+			continue
+		}
+		values := map[string]string{
+			"name": sanitizeLabelValue(aws.StringValue(image.Name)),
+			// only the day, the full timestamp is not a valid label value
+			"creation-date": strings.SplitN(aws.StringValue(image.CreationDate), "T", 2)[0],
+		}
+		for _, tag := range image.Tags {
+			values["tag."+aws.StringValue(tag.Key)] = sanitizeLabelValue(aws.StringValue(tag.Value))
+		}
+		result[aws.StringValue(instance.InstanceId)] = values
+	}
+	return result, nil
+}
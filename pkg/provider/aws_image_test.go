@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+type imagesEc2 struct {
+	Ec2API
+	images map[string]*ec2.Image
+	calls  int
+}
+
+func (f *imagesEc2) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	f.calls++
+	out := &ec2.DescribeImagesOutput{}
+	for _, id := range aws.StringValueSlice(input.ImageIds) {
+		image, ok := f.images[id]
+		if !ok {
+			return nil, awserr.New("InvalidAMIID.NotFound", "The image id '["+id+"]' does not exist", nil)
+		}
+		out.Images = append(out.Images, image)
+	}
+	return out, nil
+}
+
+func TestImageAttributes(t *testing.T) {
+	client := &imagesEc2{images: map[string]*ec2.Image{
+		"ami-1": {
+			ImageId:      aws.String("ami-1"),
+			Name:         aws.String("amazon-eks-node-1.27-v20230816"),
+			CreationDate: aws.String("2023-08-16T21:04:31.000Z"),
+			Tags:         []*ec2.Tag{{Key: aws.String("version"), Value: aws.String("v2023.08 (rc1)")}},
+		},
+	}}
+	resolver := imageAttributes{images: newImages(client)}
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), ImageId: aws.String("ami-1")},
+		{InstanceId: aws.String("i-2"), ImageId: aws.String("ami-deregistered")},
+	}
+
+	for i := 0; i < 2; i++ {
+		attributes, err := resolver.attributes(instances)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]string{
+			"i-1": {
+				"name":          "amazon-eks-node-1.27-v20230816",
+				"creation-date": "2023-08-16",
+				"tag.version":   "v2023.08-rc1",
+			},
+		}, attributes)
+	}
+	// the chunk, then each image once, deregistered images are not looked up again
+	assert.Equal(t, 3, client.calls)
+}
+
+func TestImageTagAttributeNames(t *testing.T) {
+	known := map[string]attributeResolver{"image": imageAttributes{}}
+
+	_, err := newAttributeSource("devops.apixio.com/", map[string]string{"image.tag.version": "ami-version"}, known)
+	assert.NoError(t, err)
+	_, err = newAttributeSource("devops.apixio.com/", map[string]string{"image.tag.": "ami-version"}, known)
+	assert.Error(t, err)
+}
@@ -0,0 +1,182 @@
+package provider
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/klog"
+)
+
+// awsSource adds candidate tags of instances next to their own tags.
+type awsSource interface {
+	// listTags returns tags keyed by instance id
+	listTags(instances []*ec2.Instance) (map[string][]*Tag, error)
+}
+
+// listSourceTags asks every source, a failing source is left out so the others still label nodes
+func listSourceTags(sources []awsSource, instances []*ec2.Instance) []map[string][]*Tag {
+	var result []map[string][]*Tag
+	for _, source := range sources {
+		tags, err := source.listTags(instances)
+		if err != nil {
+			klog.Errorf("Can not list tags of %d instances from %T, continuing without them. Reason: %s", len(instances), source, err.Error())
+			continue
+		}
+		result = append(result, tags)
+	}
+	return result
+}
+
+// describeInstances fetches instances in chunks, a failed chunk does not drop the others.
+// Unknown ids, e.g. of stale nodes, are left out by the filter instead of failing their chunk.
+func (p *AWSProvider) describeInstances(instanceIds []*string) ([]*ec2.Instance, *PartialError) {
+	var instances []*ec2.Instance
+	var lock sync.Mutex
+	partial := p.inChunks("instances", instanceIds, func(chunk []*string) error {
+		input := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: chunk}},
+		}
+		for {
+			output, err := p.client.DescribeInstances(input)
+			if err != nil {
+				return err
+			}
+			lock.Lock()
+			for _, reservation := range output.Reservations {
+				instances = append(instances, reservation.Instances...)
+			}
+			lock.Unlock()
+
+			if output.NextToken == nil {
+				return nil
+			}
+			input.NextToken = output.NextToken
+		}
+	})
+	return instances, partial
+}
+
+// attributeResolver returns facts about instances keyed by instance id and attribute name.
+type attributeResolver interface {
+	// names lists the attributes the resolver knows, without namespace
+	names() []string
+	attributes(instances []*ec2.Instance) (map[string]map[string]string, error)
+}
+
+// attributeSource exposes instance facts as tags, attribute names are namespaced by resolver
+// like instance.lifecycle and mapped to label keys by configuration.
+type attributeSource struct {
+	tagPrefix string
+	mapping   map[string]string
+	resolvers map[string]attributeResolver
+}
+
+// newAttributeSource enables the resolvers, keyed by namespace, the mapping needs
+func newAttributeSource(tagPrefix string, mapping map[string]string, known map[string]attributeResolver) (*attributeSource, error) {
+	source := &attributeSource{
+		tagPrefix: tagPrefix,
+		mapping:   mapping,
+		resolvers: map[string]attributeResolver{},
+	}
+	for name := range mapping {
+		parts := strings.SplitN(name, ".", 2)
+		resolver, ok := known[parts[0]]
+		if !ok || len(parts) != 2 || !resolvesAttribute(resolver, parts[1]) {
+			return nil, fmt.Errorf("unknown attribute %q, known attributes: %s", name, strings.Join(knownAttributes(known), ", "))
+		}
+		source.resolvers[parts[0]] = resolver
+	}
+	return source, nil
+}
+
+// resolvesAttribute matches names exactly, a name ending in .* matches anything below it like image.tag.version
+func resolvesAttribute(resolver attributeResolver, name string) bool {
+	for _, n := range resolver.names() {
+		if n == name {
+			return true
+		}
+		if prefix := strings.TrimSuffix(n, "*"); prefix != n && strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func knownAttributes(resolvers map[string]attributeResolver) []string {
+	var result []string
+	for namespace, resolver := range resolvers {
+		for _, name := range resolver.names() {
+			result = append(result, namespace+"."+name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// listTags maps the attributes of every resolver, a failing resolver only loses its own namespace
+func (s *attributeSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	for namespace, resolver := range s.resolvers {
+		attributes, err := resolver.attributes(instances)
+		if err != nil {
+			klog.Errorf("Can not resolve %s.* attributes of %d instances, continuing without them. Reason: %s", namespace, len(instances), err.Error())
+			continue
+		}
+		for id, values := range attributes {
+			for name, value := range values {
+				key, ok := s.mapping[namespace+"."+name]
+				if !ok || value == "" {
+					continue
+				}
+				tags[id] = append(tags[id], &Tag{Key: s.tagPrefix + key, Value: value})
+			}
+		}
+	}
+	return tags, nil
+}
+
+// instanceAttributes reads facts straight from DescribeInstances.
+type instanceAttributes struct{}
+
+func (instanceAttributes) names() []string {
+	return []string{"lifecycle", "ami-id", "vpc-id", "subnet-id", "placement-group", "tenancy", "architecture"}
+}
+
+func (instanceAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	result := map[string]map[string]string{}
+	for _, instance := range instances {
+		lifecycle := aws.StringValue(instance.InstanceLifecycle)
+		if lifecycle == "" {
+			lifecycle = "on-demand"
+		}
+		values := map[string]string{
+			"lifecycle":    lifecycle,
+			"ami-id":       aws.StringValue(instance.ImageId),
+			"vpc-id":       aws.StringValue(instance.VpcId),
+			"subnet-id":    aws.StringValue(instance.SubnetId),
+			"architecture": aws.StringValue(instance.Architecture),
+		}
+		if instance.Placement != nil {
+			values["placement-group"] = sanitizeLabelValue(aws.StringValue(instance.Placement.GroupName))
+			values["tenancy"] = aws.StringValue(instance.Placement.Tenancy)
+		}
+		result[aws.StringValue(instance.InstanceId)] = values
+	}
+	return result, nil
+}
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// sanitizeLabelValue turns free text like "Up to 10 Gigabit" into a valid label value
+func sanitizeLabelValue(value string) string {
+	value = invalidLabelValueChars.ReplaceAllString(value, "-")
+	if len(value) > 63 {
+		value = value[:63]
+	}
+	return strings.Trim(value, "-_.")
+}
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"k8s.io/klog"
+)
+
+// DescribeInstanceTypes accepts at most 100 instance types
+const instanceTypesChunkSize = 100
+
+// instanceTypes looks up instance type capabilities, they never change so results are kept forever.
+type instanceTypes struct {
+	client Ec2API
+	// snapshot is used when DescribeInstanceTypes fails, e.g. in regions without access to it
+	snapshot map[string]*ec2.InstanceTypeInfo
+
+	lock  sync.Mutex
+	cache map[string]*ec2.InstanceTypeInfo
+}
+
+// loadInstanceTypes reads a snapshot saved with: aws ec2 describe-instance-types --output json
+func loadInstanceTypes(file string) (map[string]*ec2.InstanceTypeInfo, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := struct {
+		InstanceTypes []*ec2.InstanceTypeInfo
+	}{}
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, fmt.Errorf("can not parse %s: %s", file, err.Error())
+	}
+
+	result := map[string]*ec2.InstanceTypeInfo{}
+	for _, info := range snapshot.InstanceTypes {
+		result[aws.StringValue(info.InstanceType)] = info
+	}
+	klog.Infof("Loaded %d instance types from %s", len(result), file)
+	return result, nil
+}
+
+func newInstanceTypes(client Ec2API, snapshot map[string]*ec2.InstanceTypeInfo) *instanceTypes {
+	return &instanceTypes{
+		client:   client,
+		snapshot: snapshot,
+		cache:    map[string]*ec2.InstanceTypeInfo{},
+	}
+}
+
+func (t *instanceTypes) describe(names []string) (map[string]*ec2.InstanceTypeInfo, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var missing []*string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, ok := t.cache[name]; !ok && !seen[name] {
+			seen[name] = true
+			missing = append(missing, aws.String(name))
+		}
+	}
+
+	for _, chunk := range chunkStrings(missing, instanceTypesChunkSize) {
+		if err := t.describeChunk(chunk); err != nil {
+			if !t.fromSnapshot(chunk) {
+				return nil, fmt.Errorf("can not describe instance types: %s", err.Error())
+			}
+			klog.Warningf("Using instance type snapshot. Reason: %s", err.Error())
+		}
+	}
+
+	result := map[string]*ec2.InstanceTypeInfo{}
+	for _, name := range names {
+		if info, ok := t.cache[name]; ok {
+			result[name] = info
+		}
+	}
+	return result, nil
+}
+
+// describeChunk fetches instance types into the cache, callers hold the lock
+func (t *instanceTypes) describeChunk(names []*string) error {
+	input := &ec2.DescribeInstanceTypesInput{InstanceTypes: names}
+	for {
+		output, err := t.client.DescribeInstanceTypes(input)
+		if err != nil {
+			return err
+		}
+		for _, info := range output.InstanceTypes {
+			t.cache[aws.StringValue(info.InstanceType)] = info
+		}
+		if output.NextToken == nil {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// fromSnapshot copies instance types into the cache, it reports whether the snapshot knows all of them
+func (t *instanceTypes) fromSnapshot(names []*string) bool {
+	for _, name := range aws.StringValueSlice(names) {
+		info, ok := t.snapshot[name]
+		if !ok {
+			return false
+		}
+		t.cache[name] = info
+	}
+	return true
+}
+
+// instanceTypeAttributes exposes capabilities of the instance type so workloads can select on them.
+type instanceTypeAttributes struct {
+	types *instanceTypes
+}
+
+func (instanceTypeAttributes) names() []string {
+	return []string{"vcpu", "memory-mib", "gpu-count", "gpu-manufacturer", "gpu-model", "network-performance", "nvme", "burstable"}
+}
+
+func (a instanceTypeAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	var names []string
+	for _, instance := range instances {
+		names = append(names, aws.StringValue(instance.InstanceType))
+	}
+	infos, err := a.types.describe(names)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]map[string]string{}
+	for _, instance := range instances {
+		info, ok := infos[aws.StringValue(instance.InstanceType)]
+		if !ok {
+			continue
+		}
+		result[aws.StringValue(instance.InstanceId)] = instanceTypeValues(info)
+	}
+	return result, nil
+}
+
+func instanceTypeValues(info *ec2.InstanceTypeInfo) map[string]string {
+	values := map[string]string{
+		"burstable": strconv.FormatBool(aws.BoolValue(info.BurstablePerformanceSupported)),
+		"nvme":      "false",
+		"gpu-count": "0",
+	}
+	if info.VCpuInfo != nil {
+		values["vcpu"] = strconv.FormatInt(aws.Int64Value(info.VCpuInfo.DefaultVCpus), 10)
+	}
+	if info.MemoryInfo != nil {
+		values["memory-mib"] = strconv.FormatInt(aws.Int64Value(info.MemoryInfo.SizeInMiB), 10)
+	}
+	if info.NetworkInfo != nil {
+		values["network-performance"] = sanitizeLabelValue(aws.StringValue(info.NetworkInfo.NetworkPerformance))
+	}
+	if info.InstanceStorageInfo != nil {
+		nvme := aws.StringValue(info.InstanceStorageInfo.NvmeSupport)
+		values["nvme"] = strconv.FormatBool(nvme == ec2.EphemeralNvmeSupportRequired || nvme == ec2.EphemeralNvmeSupportSupported)
+	}
+	if info.GpuInfo != nil && len(info.GpuInfo.Gpus) > 0 {
+		var count int64
+		for _, gpu := range info.GpuInfo.Gpus {
+			count += aws.Int64Value(gpu.Count)
+		}
+		values["gpu-count"] = strconv.FormatInt(count, 10)
+		values["gpu-manufacturer"] = sanitizeLabelValue(aws.StringValue(info.GpuInfo.Gpus[0].Manufacturer))
+		values["gpu-model"] = sanitizeLabelValue(aws.StringValue(info.GpuInfo.Gpus[0].Name))
+	}
+	return values
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+type instanceTypesEc2 struct {
+	Ec2API
+	types map[string]*ec2.InstanceTypeInfo
+	fail  bool
+	calls int
+}
+
+func (f *instanceTypesEc2) DescribeInstanceTypes(input *ec2.DescribeInstanceTypesInput) (*ec2.DescribeInstanceTypesOutput, error) {
+	f.calls++
+	if f.fail {
+		return nil, fmt.Errorf("UnauthorizedOperation")
+	}
+	out := &ec2.DescribeInstanceTypesOutput{}
+	for _, name := range aws.StringValueSlice(input.InstanceTypes) {
+		out.InstanceTypes = append(out.InstanceTypes, f.types[name])
+	}
+	return out, nil
+}
+
+// snapshot in the format printed by: aws ec2 describe-instance-types --output json
+const instanceTypesSnapshot = `{
+  "InstanceTypes": [
+    {
+      "InstanceType": "g4dn.xlarge",
+      "BurstablePerformanceSupported": false,
+      "VCpuInfo": {"DefaultVCpus": 4},
+      "MemoryInfo": {"SizeInMiB": 16384},
+      "NetworkInfo": {"NetworkPerformance": "Up to 25 Gigabit"},
+      "InstanceStorageInfo": {"TotalSizeInGB": 125, "NvmeSupport": "required"},
+      "GpuInfo": {"Gpus": [{"Name": "T4", "Manufacturer": "NVIDIA", "Count": 1}]}
+    }
+  ]
+}`
+
+func TestInstanceTypeAttributes(t *testing.T) {
+	client := &instanceTypesEc2{types: map[string]*ec2.InstanceTypeInfo{
+		"t3.small": {
+			InstanceType:                  aws.String("t3.small"),
+			BurstablePerformanceSupported: aws.Bool(true),
+			VCpuInfo:                      &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+			MemoryInfo:                    &ec2.MemoryInfo{SizeInMiB: aws.Int64(2048)},
+			NetworkInfo:                   &ec2.NetworkInfo{NetworkPerformance: aws.String("Up to 5 Gigabit")},
+		},
+	}}
+	resolver := instanceTypeAttributes{types: newInstanceTypes(client, nil)}
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), InstanceType: aws.String("t3.small")},
+		{InstanceId: aws.String("i-2"), InstanceType: aws.String("t3.small")},
+	}
+
+	for i := 0; i < 2; i++ {
+		attributes, err := resolver.attributes(instances)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"vcpu":                "2",
+			"memory-mib":          "2048",
+			"gpu-count":           "0",
+			"network-performance": "Up-to-5-Gigabit",
+			"nvme":                "false",
+			"burstable":           "true",
+		}, attributes["i-2"])
+	}
+	assert.Equal(t, 1, client.calls, "instance types are cached")
+}
+
+func TestInstanceTypeSnapshot(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "instance-types")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "instance-types.json")
+	writeFile(t, file, instanceTypesSnapshot)
+
+	snapshot, err := loadInstanceTypes(file)
+	assert.NoError(t, err)
+
+	client := &instanceTypesEc2{fail: true}
+	resolver := instanceTypeAttributes{types: newInstanceTypes(client, snapshot)}
+
+	attributes, err := resolver.attributes([]*ec2.Instance{{InstanceId: aws.String("i-1"), InstanceType: aws.String("g4dn.xlarge")}})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"vcpu":                "4",
+		"memory-mib":          "16384",
+		"gpu-count":           "1",
+		"gpu-manufacturer":    "NVIDIA",
+		"gpu-model":           "T4",
+		"network-performance": "Up-to-25-Gigabit",
+		"nvme":                "true",
+		"burstable":           "false",
+	}, attributes["i-1"])
+
+	_, err = resolver.attributes([]*ec2.Instance{{InstanceId: aws.String("i-2"), InstanceType: aws.String("m5.large")}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	launchTemplateIdTag      = "aws:ec2launchtemplate:id"
+	launchTemplateVersionTag = "aws:ec2launchtemplate:version"
+)
+
+// launchTemplates looks up the launch templates instances were launched from.
+type launchTemplates struct {
+	client Ec2API
+}
+
+// describe returns launch templates by id, deleted templates are left out
+func (l *launchTemplates) describe(instances []*ec2.Instance) (map[string]*ec2.LaunchTemplate, error) {
+	var ids []*string
+	seen := map[string]bool{}
+	for _, instance := range instances {
+		id := ec2TagValue(instance.Tags, launchTemplateIdTag)
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, aws.String(id))
+		}
+	}
+
+	templates := map[string]*ec2.LaunchTemplate{}
+	if len(ids) == 0 {
+		return templates, nil
+	}
+
+	err := l.describeIds(ids, templates)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidLaunchTemplateId.NotFound" {
+		// one unknown id fails the whole call, retry one by one to skip deleted templates
+		for _, id := range ids {
+			err := l.describeIds([]*string{id}, templates)
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "InvalidLaunchTemplateId.NotFound" {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return templates, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not describe launch templates: %s", err.Error())
+	}
+	return templates, nil
+}
+
+func (l *launchTemplates) describeIds(ids []*string, templates map[string]*ec2.LaunchTemplate) error {
+	input := &ec2.DescribeLaunchTemplatesInput{LaunchTemplateIds: ids}
+	for {
+		output, err := l.client.DescribeLaunchTemplates(input)
+		if err != nil {
+			return err
+		}
+		for _, template := range output.LaunchTemplates {
+			templates[aws.StringValue(template.LaunchTemplateId)] = template
+		}
+		if output.NextToken == nil {
+			return nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// launchTemplateSource adds the tags of the launch template an instance was launched from.
+type launchTemplateSource struct {
+	templates *launchTemplates
+}
+
+func (s *launchTemplateSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	templates, err := s.templates.describe(instances)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]*Tag)
+	for _, instance := range instances {
+		template, ok := templates[ec2TagValue(instance.Tags, launchTemplateIdTag)]
+		if !ok {
+			continue
+		}
+		if len(template.Tags) > 0 {
+			tags[aws.StringValue(instance.InstanceId)] = fromEc2Tags(template.Tags)
+		}
+	}
+	return tags, nil
+}
+
+// launchTemplateAttributes exposes the launch template and version an instance booted from.
+type launchTemplateAttributes struct {
+	templates *launchTemplates
+}
+
+func (launchTemplateAttributes) names() []string {
+	return []string{"id", "name", "version"}
+}
+
+func (a launchTemplateAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	templates, err := a.templates.describe(instances)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]map[string]string{}
+	for _, instance := range instances {
+		id := ec2TagValue(instance.Tags, launchTemplateIdTag)
+		if id == "" {
+			continue
+		}
+		values := map[string]string{
+			"id":      id,
+			"version": ec2TagValue(instance.Tags, launchTemplateVersionTag),
+		}
+		if template, ok := templates[id]; ok {
+			values["name"] = sanitizeLabelValue(aws.StringValue(template.LaunchTemplateName))
+		}
+		result[aws.StringValue(instance.InstanceId)] = values
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type launchTemplatesEc2 struct {
+	instancesEc2
+	templates map[string]*ec2.LaunchTemplate
+}
+
+func (f *launchTemplatesEc2) DescribeLaunchTemplates(input *ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	out := &ec2.DescribeLaunchTemplatesOutput{}
+	for _, id := range aws.StringValueSlice(input.LaunchTemplateIds) {
+		template, ok := f.templates[id]
+		if !ok {
+			return nil, awserr.New("InvalidLaunchTemplateId.NotFound", "not found", nil)
+		}
+		out.LaunchTemplates = append(out.LaunchTemplates, template)
+	}
+	return out, nil
+}
+
+func launchedFrom(id, templateId, version string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId: aws.String(id),
+		Tags: []*ec2.Tag{
+			{Key: aws.String(launchTemplateIdTag), Value: aws.String(templateId)},
+			{Key: aws.String(launchTemplateVersionTag), Value: aws.String(version)},
+		},
+	}
+}
+
+func TestLaunchTemplateSource(t *testing.T) {
+	client := &launchTemplatesEc2{
+		instancesEc2: instancesEc2{instances: map[string]*ec2.Instance{
+			"i-1": launchedFrom("i-1", "lt-1", "7"),
+			"i-2": launchedFrom("i-2", "lt-deleted", "1"),
+		}},
+		templates: map[string]*ec2.LaunchTemplate{
+			"lt-1": {
+				LaunchTemplateId:   aws.String("lt-1"),
+				LaunchTemplateName: aws.String("workers (blue)/v2"),
+				Tags:               []*ec2.Tag{{Key: aws.String("devops.apixio.com/role"), Value: aws.String("worker")}},
+			},
+		},
+	}
+	templates := &launchTemplates{client: client}
+	attributes, err := newAttributeSource("devops.apixio.com/", map[string]string{
+		"launch-template.name":    "template",
+		"launch-template.version": "template-version",
+	}, map[string]attributeResolver{"launch-template": launchTemplateAttributes{templates: templates}})
+	assert.NoError(t, err)
+
+	p := &AWSProvider{client: client}
+	p.addSource("lower", &launchTemplateSource{templates: templates})
+	p.addSource("higher", attributes)
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com/template", Value: "workers-blue-v2"},
+		{Key: "devops.apixio.com/template-version", Value: "7"},
+	}, tags["i-1"])
+	// a deleted template still reports the version the instance booted from
+	assert.Equal(t, []*Tag{{Key: "devops.apixio.com/template-version", Value: "1"}}, tags["i-2"])
+}
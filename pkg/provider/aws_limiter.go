@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/klog"
+)
+
+var throttledCalls = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tag_to_label",
+		Subsystem: "aws",
+		Name:      "throttled_calls_total",
+		Help:      "AWS calls rejected with a throttling error by operation.",
+	},
+	[]string{"operation"},
+)
+
+var callRate = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "tag_to_label",
+		Subsystem: "aws",
+		Name:      "calls_per_second",
+		Help:      "Current rate limit of AWS calls, lowered while AWS throttles.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(throttledCalls, callRate)
+}
+
+const (
+	// throttling halves the rate, down to this share of the configured rate
+	minRateShare = 0.05
+	// every successful call gives back this share of the configured rate
+	recoverRateShare = 0.02
+)
+
+// adaptiveLimiter is a token bucket shared by every AWS call. It slows down when AWS throttles,
+// so tag-to-label backs off in accounts shared with other tooling, and recovers on successful calls.
+type adaptiveLimiter struct {
+	limiter *rate.Limiter
+	max     rate.Limit
+
+	lock sync.Mutex
+}
+
+func newAdaptiveLimiter(qps float64, burst int) *adaptiveLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	callRate.Set(qps)
+	return &adaptiveLimiter{
+		limiter: rate.NewLimiter(rate.Limit(qps), burst),
+		max:     rate.Limit(qps),
+	}
+}
+
+// install makes every request of sessions and clients using handlers wait for the limiter, retries included
+func (l *adaptiveLimiter) install(handlers *request.Handlers) {
+	handlers.Send.PushFrontNamed(request.NamedHandler{
+		Name: "tag-to-label.RateLimit",
+		Fn: func(r *request.Request) {
+			// an error means the context ends before a token is available, sending fails the same way then
+			l.limiter.Wait(r.Context())
+		},
+	})
+	handlers.Retry.PushFrontNamed(request.NamedHandler{
+		Name: "tag-to-label.Throttled",
+		Fn: func(r *request.Request) {
+			if request.IsErrorThrottle(r.Error) {
+				throttledCalls.WithLabelValues(r.Operation.Name).Inc()
+				l.throttled()
+			}
+		},
+	})
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "tag-to-label.Recover",
+		Fn: func(r *request.Request) {
+			if r.Error == nil {
+				l.succeeded()
+			}
+		},
+	})
+}
+
+func (l *adaptiveLimiter) throttled() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	limit := l.limiter.Limit() / 2
+	if limit < l.max*minRateShare {
+		limit = l.max * minRateShare
+	}
+	if limit != l.limiter.Limit() {
+		klog.Warningf("AWS throttles requests, slowing down to %.2f calls per second", float64(limit))
+		l.setLimit(limit)
+	}
+}
+
+func (l *adaptiveLimiter) succeeded() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limiter.Limit() >= l.max {
+		return
+	}
+	limit := l.limiter.Limit() + l.max*recoverRateShare
+	if limit > l.max {
+		limit = l.max
+	}
+	l.setLimit(limit)
+}
+
+// setLimit changes the rate, callers hold the lock
+func (l *adaptiveLimiter) setLimit(limit rate.Limit) {
+	l.limiter.SetLimit(limit)
+	callRate.Set(float64(limit))
+}
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func TestAdaptiveLimiter(t *testing.T) {
+	throttle := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if throttle {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`<Response><Errors><Error><Code>RequestLimitExceeded</Code><Message>Request limit exceeded.</Message></Error></Errors><RequestID>1</RequestID></Response>`))
+			return
+		}
+		w.Write([]byte(`<DescribeTagsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><tagSet/></DescribeTagsResponse>`))
+	}))
+	defer server.Close()
+
+	awsSession := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("KEY", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	limiter := newAdaptiveLimiter(1000, 10)
+	limiter.install(&awsSession.Handlers)
+	client := ec2.New(awsSession)
+	before := testutil.ToFloat64(throttledCalls.WithLabelValues("DescribeTags"))
+
+	for i := 0; i < 10; i++ {
+		_, err := client.DescribeTags(&ec2.DescribeTagsInput{})
+		assert.Error(t, err)
+	}
+	assert.Equal(t, float64(10), testutil.ToFloat64(throttledCalls.WithLabelValues("DescribeTags"))-before)
+	assert.Equal(t, rate.Limit(50), limiter.limiter.Limit(), "the rate never drops below its floor")
+
+	throttle = false
+	for i := 0; i < 60; i++ {
+		_, err := client.DescribeTags(&ec2.DescribeTagsInput{})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, rate.Limit(1000), limiter.limiter.Limit(), "successful calls restore the rate")
+}
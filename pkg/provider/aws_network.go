@@ -0,0 +1,98 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// networkSource adds tags of the VPC and subnet an instance runs in, subnet tags win over VPC tags.
+type networkSource struct {
+	client Ec2API
+}
+
+func (s *networkSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	var subnetIds, vpcIds []*string
+	seen := map[string]bool{}
+	for _, instance := range instances {
+		if id := aws.StringValue(instance.SubnetId); id != "" && !seen[id] {
+			seen[id] = true
+			subnetIds = append(subnetIds, instance.SubnetId)
+		}
+		if id := aws.StringValue(instance.VpcId); id != "" && !seen[id] {
+			seen[id] = true
+			vpcIds = append(vpcIds, instance.VpcId)
+		}
+	}
+
+	subnetTags, err := s.describeSubnets(subnetIds)
+	if err != nil {
+		return nil, err
+	}
+	vpcTags, err := s.describeVpcs(vpcIds)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]*Tag)
+	for _, instance := range instances {
+		var merged []*Tag
+		merged = append(merged, vpcTags[aws.StringValue(instance.VpcId)]...)
+		merged = append(merged, subnetTags[aws.StringValue(instance.SubnetId)]...)
+		if len(merged) > 0 {
+			tags[aws.StringValue(instance.InstanceId)] = merged
+		}
+	}
+	return tags, nil
+}
+
+func (s *networkSource) describeSubnets(ids []*string) (map[string][]*Tag, error) {
+	tags := map[string][]*Tag{}
+	for _, chunk := range chunkStrings(ids, defaultDescribeTagsChunkSize) {
+		input := &ec2.DescribeSubnetsInput{SubnetIds: chunk}
+		for {
+			output, err := s.client.DescribeSubnets(input)
+			if err != nil {
+				return nil, fmt.Errorf("can not describe subnets: %s", err.Error())
+			}
+			for _, subnet := range output.Subnets {
+				tags[aws.StringValue(subnet.SubnetId)] = fromEc2Tags(subnet.Tags)
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return tags, nil
+}
+
+func (s *networkSource) describeVpcs(ids []*string) (map[string][]*Tag, error) {
+	tags := map[string][]*Tag{}
+	for _, chunk := range chunkStrings(ids, defaultDescribeTagsChunkSize) {
+		input := &ec2.DescribeVpcsInput{VpcIds: chunk}
+		for {
+			output, err := s.client.DescribeVpcs(input)
+			if err != nil {
+				return nil, fmt.Errorf("can not describe vpcs: %s", err.Error())
+			}
+			for _, vpc := range output.Vpcs {
+				tags[aws.StringValue(vpc.VpcId)] = fromEc2Tags(vpc.Tags)
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return tags, nil
+}
+
+func fromEc2Tags(ec2Tags []*ec2.Tag) []*Tag {
+	var tags []*Tag
+	for _, tag := range ec2Tags {
+		tags = append(tags, &Tag{Key: aws.StringValue(tag.Key), Value: aws.StringValue(tag.Value)})
+	}
+	return tags
+}
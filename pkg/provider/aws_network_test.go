@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+type networkEc2 struct {
+	instancesEc2
+	subnets map[string][]*ec2.Tag
+	vpcs    map[string][]*ec2.Tag
+}
+
+func (f *networkEc2) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, id := range input.SubnetIds {
+		out.Subnets = append(out.Subnets, &ec2.Subnet{SubnetId: id, Tags: f.subnets[aws.StringValue(id)]})
+	}
+	return out, nil
+}
+
+func (f *networkEc2) DescribeVpcs(input *ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+	out := &ec2.DescribeVpcsOutput{}
+	for _, id := range input.VpcIds {
+		out.Vpcs = append(out.Vpcs, &ec2.Vpc{VpcId: id, Tags: f.vpcs[aws.StringValue(id)]})
+	}
+	return out, nil
+}
+
+func TestNetworkSource(t *testing.T) {
+	client := &networkEc2{
+		instancesEc2: instancesEc2{
+			tags: map[string][]*ec2.TagDescription{
+				"i-1": {{ResourceId: aws.String("i-1"), Key: aws.String("devops.apixio.com/tier"), Value: aws.String("instance")}},
+			},
+			instances: map[string]*ec2.Instance{
+				"i-1": {InstanceId: aws.String("i-1"), SubnetId: aws.String("subnet-1"), VpcId: aws.String("vpc-1")},
+				"i-2": {InstanceId: aws.String("i-2"), SubnetId: aws.String("subnet-2"), VpcId: aws.String("vpc-1")},
+			},
+		},
+		subnets: map[string][]*ec2.Tag{
+			"subnet-1": {{Key: aws.String("devops.apixio.com/tier"), Value: aws.String("private")}},
+			"subnet-2": {{Key: aws.String("devops.apixio.com/tier"), Value: aws.String("public")}},
+		},
+		vpcs: map[string][]*ec2.Tag{
+			"vpc-1": {
+				{Key: aws.String("devops.apixio.com/tier"), Value: aws.String("vpc")},
+				{Key: aws.String("devops.apixio.com/network-zone"), Value: aws.String("pci")},
+			},
+		},
+	}
+	p := &AWSProvider{client: client}
+	p.addSource("lower", &networkSource{client: client})
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "instance", labelValue(tags["i-1"], "devops.apixio.com/tier"))
+	assert.Equal(t, "pci", labelValue(tags["i-1"], "devops.apixio.com/network-zone"))
+	assert.Equal(t, "public", labelValue(tags["i-2"], "devops.apixio.com/tier"))
+	assert.Equal(t, "pci", labelValue(tags["i-2"], "devops.apixio.com/network-zone"))
+}
@@ -0,0 +1,243 @@
+package provider
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// regionPattern takes the region out of zone names, including local and wavelength zones like us-west-2-lax-1a
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(?:-[a-z]+)+-\d+`)
+
+// awsRegionKey identifies the provider of a region and account, role is empty for this account
+type awsRegionKey struct {
+	region string
+	role   string
+}
+
+// accountTTL is how long the account of an instance is remembered after its last lookup. The checker
+// looks up every node far more often, so only instances of nodes which left the cluster expire.
+const accountTTL = time.Hour
+
+// awsAccount is the role an instance was found with, "" for this account
+type awsAccount struct {
+	role string
+	seen time.Time
+}
+
+// regionOf returns the region of a node from the zone in its ProviderID, empty when it has none
+// ProviderID: aws:///us-west-2c/i-08aab319ad2b55083
+func regionOf(node *corev1.Node) string {
+	parts := strings.Split(node.Spec.ProviderID, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return regionPattern.FindString(parts[len(parts)-2])
+}
+
+// ListTags looks up nodes in the region they run in, a region failing does not drop the others.
+func (p *AWSProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	byRegion := map[string][]*string{}
+	for _, no := range nodes {
+		id, err := p.InstanceID(no)
+		if err != nil {
+			return nil, err
+		}
+		region := regionOf(no)
+		if region == "" {
+			region = p.region
+		}
+		byRegion[region] = append(byRegion[region], aws.String(id))
+	}
+
+	if len(byRegion) == 1 && len(p.roles) == 0 {
+		for region, ids := range byRegion {
+			regional, err := p.regionalProvider(awsRegionKey{region: region})
+			if err != nil {
+				return nil, err
+			}
+			return regional.lookup(ids)
+		}
+	}
+
+	var regions []string
+	for region := range byRegion {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	result := make(map[string][]*Tag)
+	var partial *PartialError
+	for _, region := range regions {
+		tags, err := p.lookupRegion(region, byRegion[region])
+		for id, t := range tags {
+			result[id] = t
+		}
+		partial = partial.merge(asPartial(err, byRegion[region]))
+	}
+	return result, partial.orNil()
+}
+
+// lookupRegion fetches tags of instances in one region, trying other accounts for instances this one does not know
+func (p *AWSProvider) lookupRegion(region string, ids []*string) (map[string][]*Tag, error) {
+	byRole := map[string][]*string{}
+	var unknown []*string
+	now := time.Now()
+	p.lock.Lock()
+	p.sweepAccounts(now)
+	for _, id := range ids {
+		account, ok := p.accounts[aws.StringValue(id)]
+		if !ok && len(p.roles) > 0 {
+			unknown = append(unknown, id)
+			continue
+		}
+		if ok {
+			account.seen = now
+			p.accounts[aws.StringValue(id)] = account
+		}
+		byRole[account.role] = append(byRole[account.role], id)
+	}
+	p.lock.Unlock()
+
+	result := make(map[string][]*Tag)
+	var partial *PartialError
+	for role, roleIds := range byRole {
+		tags, err := p.lookupAccount(awsRegionKey{region: region, role: role}, roleIds)
+		for id, t := range tags {
+			result[id] = t
+		}
+		partial = partial.merge(asPartial(err, roleIds))
+	}
+
+	// an account failing to answer may own the instances the others do not know, they are failed rather than missing
+	var probeErr error
+	for _, role := range append([]string{""}, p.roles...) {
+		if len(unknown) == 0 {
+			break
+		}
+		key := awsRegionKey{region: region, role: role}
+		found, err := p.findInstances(key, unknown)
+		if err != nil {
+			klog.Errorf("Can not look for %d instances in %s with role %q. Reason: %s", len(unknown), region, role, err.Error())
+			probeErr = err
+			continue
+		}
+		var rest, owned []*string
+		for _, id := range unknown {
+			if found[aws.StringValue(id)] {
+				owned = append(owned, id)
+			} else {
+				rest = append(rest, id)
+			}
+		}
+		unknown = rest
+		if len(owned) == 0 {
+			continue
+		}
+
+		p.lock.Lock()
+		for _, id := range owned {
+			p.accounts[aws.StringValue(id)] = awsAccount{role: role, seen: now}
+		}
+		p.lock.Unlock()
+		tags, err := p.lookupAccount(key, owned)
+		for id, t := range tags {
+			result[id] = t
+		}
+		partial = partial.merge(asPartial(err, owned))
+	}
+	if len(unknown) > 0 && probeErr != nil {
+		partial = partial.merge(asPartial(probeErr, unknown))
+	} else if len(unknown) > 0 {
+		klog.Warningf("%d instances not found in %s with any account", len(unknown), region)
+	}
+	return result, partial.orNil()
+}
+
+// sweepAccounts forgets instances not looked up for accountTTL, callers hold the lock
+func (p *AWSProvider) sweepAccounts(now time.Time) {
+	if now.Sub(p.accountsSwept) < accountTTL {
+		return
+	}
+	p.accountsSwept = now
+	for id, account := range p.accounts {
+		if now.Sub(account.seen) > accountTTL {
+			delete(p.accounts, id)
+		}
+	}
+}
+
+func (p *AWSProvider) lookupAccount(key awsRegionKey, ids []*string) (map[string][]*Tag, error) {
+	regional, err := p.regionalProvider(key)
+	if err != nil {
+		return nil, err
+	}
+	return regional.lookup(ids)
+}
+
+// findInstances reports which instances exist in a region and account, unknown ids do not fail the call with a filter
+func (p *AWSProvider) findInstances(key awsRegionKey, ids []*string) (map[string]bool, error) {
+	regional, err := p.regionalProvider(key)
+	if err != nil {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for _, chunk := range chunkStrings(ids, defaultDescribeTagsChunkSize) {
+		input := &ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: chunk}},
+		}
+		for {
+			output, err := regional.client.DescribeInstances(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, reservation := range output.Reservations {
+				for _, instance := range reservation.Instances {
+					found[aws.StringValue(instance.InstanceId)] = true
+				}
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return found, nil
+}
+
+// regionalProvider returns the provider of a region and account, creating it on first use
+func (p *AWSProvider) regionalProvider(key awsRegionKey) (*AWSProvider, error) {
+	if p.newRegional == nil || (key.role == "" && (key.region == p.region || key.region == "")) {
+		return p, nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if regional, ok := p.regional[key]; ok {
+		return regional, nil
+	}
+	regional, err := p.newRegional(key)
+	if err != nil {
+		return nil, err
+	}
+	p.regional[key] = regional
+	return regional, nil
+}
+
+// asPartial reports every id as failed unless err already tells which ones did
+func asPartial(err error, ids []*string) *PartialError {
+	if err == nil {
+		return nil
+	}
+	if partial, ok := err.(*PartialError); ok {
+		return partial
+	}
+	klog.Errorf("Can not look up %d instances. Reason: %s", len(ids), err.Error())
+	return &PartialError{Failed: aws.StringValueSlice(ids), Err: err}
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// accountEc2 only knows its own instances, like EC2 in one region and account
+type accountEc2 struct {
+	instancesEc2
+	probes int
+	// probeErr fails every probe, like a role that can not be assumed
+	probeErr error
+}
+
+func (f *accountEc2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	if len(input.Filters) > 0 {
+		f.probes++
+		if f.probeErr != nil {
+			return nil, f.probeErr
+		}
+	}
+	return f.instancesEc2.DescribeInstances(input)
+}
+
+func newAccountEc2(ids ...string) *accountEc2 {
+	client := &accountEc2{instancesEc2: instancesEc2{
+		tags:      map[string][]*ec2.TagDescription{},
+		instances: map[string]*ec2.Instance{},
+	}}
+	for _, id := range ids {
+		client.instances[id] = &ec2.Instance{InstanceId: aws.String(id)}
+		client.tags[id] = []*ec2.TagDescription{{ResourceId: aws.String(id), Key: aws.String("devops.apixio.com/id"), Value: aws.String(id)}}
+	}
+	return client
+}
+
+func newRegionalTestProvider(home string, regions map[awsRegionKey]Ec2API, roles ...string) *AWSProvider {
+	key := awsRegionKey{region: home}
+	return &AWSProvider{
+		client:   regions[key],
+		region:   home,
+		roles:    roles,
+		regional: map[awsRegionKey]*AWSProvider{},
+		accounts: map[string]awsAccount{},
+		newRegional: func(key awsRegionKey) (*AWSProvider, error) {
+			client, ok := regions[key]
+			if !ok {
+				return nil, fmt.Errorf("no region %v", key)
+			}
+			return &AWSProvider{client: client}, nil
+		},
+	}
+}
+
+func TestRegionOf(t *testing.T) {
+	for providerID, region := range map[string]string{
+		"aws:///us-west-2c/i-1":              "us-west-2",
+		"aws:///us-west-2-lax-1a/i-1":        "us-west-2",
+		"aws:///us-east-1-wl1-bos-wlz-1/i-1": "us-east-1",
+		"aws:///us-gov-west-1a/i-1":          "us-gov-west-1",
+		"aws:///i-1":                         "",
+	} {
+		assert.Equal(t, region, regionOf(newNode("node", providerID)), providerID)
+	}
+}
+
+func TestAWSListTagsRegions(t *testing.T) {
+	p := newRegionalTestProvider("us-west-2", map[awsRegionKey]Ec2API{
+		{region: "us-west-2"}: newAccountEc2("i-1", "i-3"),
+		{region: "eu-west-1"}: newAccountEc2("i-2"),
+	})
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///eu-west-1a/i-2"),
+		newNode("node3", "aws:///i-3"),
+		newNode("node4", "aws:///ap-south-1a/i-4"),
+	})
+	assert.Equal(t, map[string][]*Tag{
+		"i-1": {{Key: "devops.apixio.com/id", Value: "i-1"}},
+		"i-2": {{Key: "devops.apixio.com/id", Value: "i-2"}},
+		"i-3": {{Key: "devops.apixio.com/id", Value: "i-3"}},
+	}, tags)
+	partial, ok := err.(*PartialError)
+	assert.True(t, ok, "a region without client fails only its nodes")
+	assert.Equal(t, []string{"i-4"}, partial.Failed)
+}
+
+func TestAWSListTagsAccounts(t *testing.T) {
+	role := "arn:aws:iam::123456789012:role/tag-to-label"
+	home := newAccountEc2("i-1")
+	other := newAccountEc2("i-2")
+	p := newRegionalTestProvider("us-west-2", map[awsRegionKey]Ec2API{
+		{region: "us-west-2"}:             home,
+		{region: "us-west-2", role: role}: other,
+	}, role)
+	nodes := []*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+		newNode("node3", "aws:///us-west-2c/i-3"),
+	}
+
+	for i := 0; i < 2; i++ {
+		tags, err := p.ListTags(nodes)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string][]*Tag{
+			"i-1": {{Key: "devops.apixio.com/id", Value: "i-1"}},
+			"i-2": {{Key: "devops.apixio.com/id", Value: "i-2"}},
+		}, tags)
+	}
+	assert.Equal(t, map[string]string{"i-1": "", "i-2": role}, accountRoles(p))
+	// found instances are not probed again, only the one no account knows
+	assert.Equal(t, 2, home.probes)
+	assert.Equal(t, 2, other.probes)
+
+	// instances of nodes no longer looked up are forgotten
+	p.lock.Lock()
+	p.sweepAccounts(time.Now().Add(2 * accountTTL))
+	p.lock.Unlock()
+	assert.Empty(t, accountRoles(p))
+}
+
+func TestAWSListTagsAccountFails(t *testing.T) {
+	denied, role := "arn:aws:iam::111111111111:role/tag-to-label", "arn:aws:iam::123456789012:role/tag-to-label"
+	failing := newAccountEc2()
+	failing.probeErr = fmt.Errorf("AccessDenied")
+	p := newRegionalTestProvider("us-west-2", map[awsRegionKey]Ec2API{
+		{region: "us-west-2"}:               newAccountEc2("i-1"),
+		{region: "us-west-2", role: denied}: failing,
+		{region: "us-west-2", role: role}:   newAccountEc2("i-2"),
+	}, denied, role)
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+		newNode("node3", "aws:///us-west-2c/i-3"),
+	})
+	assert.Len(t, tags, 2, "accounts after the failing one are still asked")
+	assert.Contains(t, tags, "i-2")
+	partial, ok := err.(*PartialError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"i-3"}, partial.Failed, "the failing account may own the instance nobody else knows")
+}
+
+func accountRoles(p *AWSProvider) map[string]string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	roles := map[string]string{}
+	for id, account := range p.accounts {
+		roles[id] = account.role
+	}
+	return roles
+}
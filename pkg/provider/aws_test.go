@@ -1,3 +1,279 @@
 package provider
 
-//TODO
\ No newline at end of file
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeEc2 struct {
+	Ec2API
+	pages  []*ec2.DescribeTagsOutput
+	calls  int
+	inputs []*ec2.DescribeTagsInput
+}
+
+func (f *fakeEc2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	f.inputs = append(f.inputs, input)
+	out := f.pages[f.calls]
+	f.calls++
+	return out, nil
+}
+
+func newNode(name, providerID string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       corev1.NodeSpec{ProviderID: providerID},
+	}
+}
+
+func TestAWSInstanceID(t *testing.T) {
+	p := &AWSProvider{}
+	id, err := p.InstanceID(newNode("node1", "aws:///us-west-2c/i-08aab319ad2b55083"))
+	assert.NoError(t, err)
+	assert.Equal(t, "i-08aab319ad2b55083", id)
+
+	_, err = p.InstanceID(newNode("node2", "gce://project/zone/instance"))
+	assert.Error(t, err)
+}
+
+func TestAWSListTags(t *testing.T) {
+	client := &fakeEc2{pages: []*ec2.DescribeTagsOutput{
+		{
+			Tags: []*ec2.TagDescription{
+				{ResourceId: aws.String("i-1"), Key: aws.String("k1"), Value: aws.String("v1")},
+			},
+			NextToken: aws.String("next"),
+		},
+		{
+			Tags: []*ec2.TagDescription{
+				{ResourceId: aws.String("i-1"), Key: aws.String("k2"), Value: aws.String("v2")},
+				{ResourceId: aws.String("i-2"), Key: aws.String("k1"), Value: aws.String("v3")},
+			},
+		},
+	}}
+	p := &AWSProvider{client: client}
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, client.calls)
+	expect := map[string][]*Tag{
+		"i-1": {{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2"}},
+		"i-2": {{Key: "k1", Value: "v3"}},
+	}
+	assert.True(t, assert.ObjectsAreEqual(expect, tags))
+}
+
+func TestAWSListTagsKeyFilter(t *testing.T) {
+	client := &fakeEc2{pages: []*ec2.DescribeTagsOutput{{}}}
+	p := &AWSProvider{client: client, tagKeys: aws.StringSlice([]string{"devops.apixio.com/*"})}
+
+	_, err := p.ListTags([]*corev1.Node{newNode("node1", "aws:///us-west-2c/i-1")})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ec2.Filter{
+		{Name: aws.String("resource-id"), Values: aws.StringSlice([]string{"i-1"})},
+		{Name: aws.String("key"), Values: aws.StringSlice([]string{"devops.apixio.com/*"})},
+	}, client.inputs[0].Filters)
+}
+
+// chunkedEc2 answers DescribeTags with one tag per requested instance and fails chunks containing i-bad
+type chunkedEc2 struct {
+	Ec2API
+	lock   sync.Mutex
+	chunks [][]string
+}
+
+func (f *chunkedEc2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	ids := aws.StringValueSlice(input.Filters[0].Values)
+	f.lock.Lock()
+	f.chunks = append(f.chunks, ids)
+	f.lock.Unlock()
+
+	out := &ec2.DescribeTagsOutput{}
+	for _, id := range ids {
+		if id == "i-bad" {
+			return nil, fmt.Errorf("boom")
+		}
+		out.Tags = append(out.Tags, &ec2.TagDescription{ResourceId: aws.String(id), Key: aws.String("k"), Value: aws.String(id)})
+	}
+	return out, nil
+}
+
+func TestAWSListTagsChunked(t *testing.T) {
+	client := &chunkedEc2{}
+	p := &AWSProvider{client: client, chunkSize: 2, concurrency: 2}
+
+	var nodes []*corev1.Node
+	for _, id := range []string{"i-1", "i-2", "i-3", "i-bad", "i-5"} {
+		nodes = append(nodes, newNode(id, "aws:///us-west-2c/"+id))
+	}
+
+	tags, err := p.ListTags(nodes)
+	assert.Len(t, client.chunks, 3)
+	for _, chunk := range client.chunks {
+		assert.True(t, len(chunk) <= 2)
+	}
+
+	partial, ok := err.(*PartialError)
+	assert.True(t, ok)
+	sort.Strings(partial.Failed)
+	assert.Equal(t, []string{"i-3", "i-bad"}, partial.Failed)
+
+	var found []string
+	for id := range tags {
+		found = append(found, id)
+	}
+	sort.Strings(found)
+	assert.Equal(t, []string{"i-1", "i-2", "i-5"}, found)
+}
+
+// instancesEc2 serves tags and instances from fixed maps
+type instancesEc2 struct {
+	Ec2API
+	tags      map[string][]*ec2.TagDescription
+	instances map[string]*ec2.Instance
+}
+
+func (f *instancesEc2) DescribeTags(input *ec2.DescribeTagsInput) (*ec2.DescribeTagsOutput, error) {
+	out := &ec2.DescribeTagsOutput{}
+	for _, id := range aws.StringValueSlice(input.Filters[0].Values) {
+		out.Tags = append(out.Tags, f.tags[id]...)
+	}
+	return out, nil
+}
+
+// DescribeInstances fails on unknown InstanceIds like EC2 does, the instance-id filter leaves them out
+func (f *instancesEc2) DescribeInstances(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	reservation := &ec2.Reservation{}
+	for _, id := range aws.StringValueSlice(input.InstanceIds) {
+		instance, ok := f.instances[id]
+		if !ok {
+			return nil, awserr.New("InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", id), nil)
+		}
+		reservation.Instances = append(reservation.Instances, instance)
+	}
+	for _, filter := range input.Filters {
+		if aws.StringValue(filter.Name) != "instance-id" {
+			continue
+		}
+		for _, id := range aws.StringValueSlice(filter.Values) {
+			if instance, ok := f.instances[id]; ok {
+				reservation.Instances = append(reservation.Instances, instance)
+			}
+		}
+	}
+	return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{reservation}}, nil
+}
+
+func TestAWSListTagsAttributes(t *testing.T) {
+	client := &instancesEc2{
+		tags: map[string][]*ec2.TagDescription{
+			"i-1": {{ResourceId: aws.String("i-1"), Key: aws.String("devops.apixio.com/role"), Value: aws.String("worker")}},
+		},
+		instances: map[string]*ec2.Instance{
+			"i-1": {InstanceId: aws.String("i-1"), ImageId: aws.String("ami-1"), InstanceLifecycle: aws.String("spot")},
+			"i-2": {InstanceId: aws.String("i-2"), ImageId: aws.String("ami-2"), Placement: &ec2.Placement{Tenancy: aws.String("default"), GroupName: aws.String("spread (rack/1)")}},
+		},
+	}
+	attributes, err := newAttributeSource("devops.apixio.com/", map[string]string{
+		"instance.lifecycle":       "lifecycle",
+		"instance.ami-id":          "node.apixio.com/ami",
+		"instance.tenancy":         "tenancy",
+		"instance.placement-group": "placement-group",
+	}, map[string]attributeResolver{"instance": instanceAttributes{}})
+	assert.NoError(t, err)
+	p := &AWSProvider{client: client, after: []awsSource{attributes}}
+
+	// a stale node does not fail the lookup of the others
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+		newNode("node3", "aws:///us-west-2c/i-gone"),
+	})
+	assert.NoError(t, err)
+	assert.Len(t, tags, 2)
+	assert.Equal(t, &Tag{Key: "devops.apixio.com/role", Value: "worker"}, tags["i-1"][0])
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com/lifecycle", Value: "spot"},
+		{Key: "devops.apixio.com/node.apixio.com/ami", Value: "ami-1"},
+	}, tags["i-1"])
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/lifecycle", Value: "on-demand"},
+		{Key: "devops.apixio.com/node.apixio.com/ami", Value: "ami-2"},
+		{Key: "devops.apixio.com/tenancy", Value: "default"},
+		{Key: "devops.apixio.com/placement-group", Value: "spread-rack-1"},
+	}, tags["i-2"])
+
+	_, err = newAttributeSource("devops.apixio.com/", map[string]string{"instance.color": "color"}, map[string]attributeResolver{"instance": instanceAttributes{}})
+	assert.Error(t, err)
+}
+
+// deniedSource fails like a source the role has no permission for
+type deniedSource struct{}
+
+func (deniedSource) listTags(instances []*ec2.Instance) (map[string][]*Tag, error) {
+	return nil, awserr.New("AccessDenied", "not authorized", nil)
+}
+
+// deniedAttributes fails like a resolver the role has no permission for
+type deniedAttributes struct{}
+
+func (deniedAttributes) names() []string {
+	return []string{"name"}
+}
+
+func (deniedAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	return nil, awserr.New("UnauthorizedOperation", "not authorized", nil)
+}
+
+func TestAWSListTagsFailingAttributes(t *testing.T) {
+	client := &instancesEc2{
+		instances: map[string]*ec2.Instance{
+			"i-1": {InstanceId: aws.String("i-1"), InstanceLifecycle: aws.String("spot")},
+		},
+	}
+	attributes, err := newAttributeSource("devops.apixio.com/", map[string]string{
+		"instance.lifecycle": "lifecycle",
+		"image.name":         "image",
+	}, map[string]attributeResolver{"instance": instanceAttributes{}, "image": deniedAttributes{}})
+	assert.NoError(t, err)
+	p := &AWSProvider{client: client, after: []awsSource{attributes}}
+
+	tags, err := p.ListTags([]*corev1.Node{newNode("node1", "aws:///us-west-2c/i-1")})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Tag{{Key: "devops.apixio.com/lifecycle", Value: "spot"}}, tags["i-1"])
+}
+
+func TestAWSListTagsFailingSource(t *testing.T) {
+	client := &instancesEc2{
+		tags: map[string][]*ec2.TagDescription{
+			"i-1": {{ResourceId: aws.String("i-1"), Key: aws.String("devops.apixio.com/role"), Value: aws.String("worker")}},
+		},
+		instances: map[string]*ec2.Instance{
+			"i-1": {InstanceId: aws.String("i-1"), InstanceLifecycle: aws.String("spot")},
+		},
+	}
+	attributes, err := newAttributeSource("devops.apixio.com/", map[string]string{"instance.lifecycle": "lifecycle"},
+		map[string]attributeResolver{"instance": instanceAttributes{}})
+	assert.NoError(t, err)
+	p := &AWSProvider{client: client, before: []awsSource{deniedSource{}}, after: []awsSource{attributes, deniedSource{}}}
+
+	tags, err := p.ListTags([]*corev1.Node{newNode("node1", "aws:///us-west-2c/i-1")})
+	assert.NoError(t, err)
+	assert.Equal(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com/lifecycle", Value: "spot"},
+	}, tags["i-1"])
+}
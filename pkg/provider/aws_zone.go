@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// availabilityZones looks up the zones of the region once, zones of a region hardly ever change.
+type availabilityZones struct {
+	client Ec2API
+
+	lock  sync.Mutex
+	zones map[string]*ec2.AvailabilityZone
+}
+
+// describe returns zones by name, including local and wavelength zones the account did not opt in to
+func (z *availabilityZones) describe() (map[string]*ec2.AvailabilityZone, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	if z.zones != nil {
+		return z.zones, nil
+	}
+	output, err := z.client.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{AllAvailabilityZones: aws.Bool(true)})
+	if err != nil {
+		return nil, fmt.Errorf("can not describe availability zones: %s", err.Error())
+	}
+	zones := map[string]*ec2.AvailabilityZone{}
+	for _, zone := range output.AvailabilityZones {
+		zones[aws.StringValue(zone.ZoneName)] = zone
+	}
+	z.zones = zones
+	return zones, nil
+}
+
+// zoneAttributes exposes the zone an instance runs in beyond its name, which is in the ProviderID already.
+type zoneAttributes struct {
+	zones *availabilityZones
+}
+
+func (zoneAttributes) names() []string {
+	return []string{"zone-id", "zone-type", "parent-zone", "group"}
+}
+
+func (a zoneAttributes) attributes(instances []*ec2.Instance) (map[string]map[string]string, error) {
+	zones, err := a.zones.describe()
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]map[string]string{}
+	for _, instance := range instances {
+		if instance.Placement == nil {
+			continue
+		}
+		zone, ok := zones[aws.StringValue(instance.Placement.AvailabilityZone)]
+		if !ok {
+			continue
+		}
+		result[aws.StringValue(instance.InstanceId)] = map[string]string{
+			"zone-id":     aws.StringValue(zone.ZoneId),
+			"zone-type":   aws.StringValue(zone.ZoneType),
+			"parent-zone": aws.StringValue(zone.ParentZoneName),
+			"group":       aws.StringValue(zone.GroupName),
+		}
+	}
+	return result, nil
+}
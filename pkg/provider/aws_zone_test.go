@@ -0,0 +1,54 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+type zonesEc2 struct {
+	Ec2API
+	zones []*ec2.AvailabilityZone
+	calls int
+}
+
+func (f *zonesEc2) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	f.calls++
+	return &ec2.DescribeAvailabilityZonesOutput{AvailabilityZones: f.zones}, nil
+}
+
+func TestZoneAttributes(t *testing.T) {
+	client := &zonesEc2{zones: []*ec2.AvailabilityZone{
+		{
+			ZoneName:  aws.String("us-west-2a"),
+			ZoneId:    aws.String("usw2-az2"),
+			ZoneType:  aws.String("availability-zone"),
+			GroupName: aws.String("us-west-2"),
+		},
+		{
+			ZoneName:       aws.String("us-west-2-lax-1a"),
+			ZoneId:         aws.String("usw2-lax1-az1"),
+			ZoneType:       aws.String("local-zone"),
+			ParentZoneName: aws.String("us-west-2d"),
+			GroupName:      aws.String("us-west-2-lax-1"),
+		},
+	}}
+	resolver := zoneAttributes{zones: &availabilityZones{client: client}}
+	instances := []*ec2.Instance{
+		{InstanceId: aws.String("i-1"), Placement: &ec2.Placement{AvailabilityZone: aws.String("us-west-2a")}},
+		{InstanceId: aws.String("i-2"), Placement: &ec2.Placement{AvailabilityZone: aws.String("us-west-2-lax-1a")}},
+		{InstanceId: aws.String("i-3")},
+	}
+
+	for i := 0; i < 2; i++ {
+		attributes, err := resolver.attributes(instances)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]map[string]string{
+			"i-1": {"zone-id": "usw2-az2", "zone-type": "availability-zone", "parent-zone": "", "group": "us-west-2"},
+			"i-2": {"zone-id": "usw2-lax1-az1", "zone-type": "local-zone", "parent-zone": "us-west-2d", "group": "us-west-2-lax-1"},
+		}, attributes)
+	}
+	assert.Equal(t, 1, client.calls, "zones are described once")
+}
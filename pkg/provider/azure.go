@@ -0,0 +1,201 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	defaultAzureEndpoint         = "https://management.azure.com"
+	defaultAzureMetadataEndpoint = "http://169.254.169.254"
+	azureAPIVersion              = "2021-03-01"
+)
+
+var (
+	// azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachines/<name>
+	azureVMRegexp = regexp.MustCompile(`(?i)^azure:///subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/virtualMachines/([^/]+)$`)
+	// azure:///subscriptions/<sub>/resourceGroups/<rg>/providers/Microsoft.Compute/virtualMachineScaleSets/<ss>/virtualMachines/<id>
+	azureVMSSRegexp = regexp.MustCompile(`(?i)^azure:///subscriptions/([^/]+)/resourceGroups/([^/]+)/providers/Microsoft\.Compute/virtualMachineScaleSets/([^/]+)/virtualMachines/([^/]+)$`)
+)
+
+// AzureConfig contains configuration to create a new Azure provider.
+type AzureConfig struct {
+	// Endpoint of the ARM API, override it to test against a local server
+	Endpoint string
+	// MetadataEndpoint is used to get an access token of the managed identity
+	MetadataEndpoint string
+	// TagKeyPrefix limits exposed tags to the ones starting with it, the prefix is stripped
+	TagKeyPrefix string
+	// TagPrefix is prepended to every key, Azure tag names can not contain '/'
+	TagPrefix string
+	Timeout   time.Duration
+}
+
+// AzureProvider reads VM and VMSS instance tags through the ARM REST API.
+type AzureProvider struct {
+	config AzureConfig
+	client *http.Client
+}
+
+type azureResource struct {
+	Tags map[string]string `json:"tags"`
+}
+
+type azureToken struct {
+	AccessToken string `json:"access_token"`
+}
+
+// azureVM points to a standalone VM or, when ScaleSet is set, to a VMSS instance
+type azureVM struct {
+	Subscription  string
+	ResourceGroup string
+	ScaleSet      string
+	Name          string
+}
+
+func (vm *azureVM) resourcePath() string {
+	path := fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute", vm.Subscription, vm.ResourceGroup)
+	if vm.ScaleSet != "" {
+		return fmt.Sprintf("%s/virtualMachineScaleSets/%s/virtualMachines/%s", path, vm.ScaleSet, vm.Name)
+	}
+	return fmt.Sprintf("%s/virtualMachines/%s", path, vm.Name)
+}
+
+func (vm *azureVM) scaleSetPath() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachineScaleSets/%s", vm.Subscription, vm.ResourceGroup, vm.ScaleSet)
+}
+
+func NewAzureProvider(azureConfig AzureConfig) (*AzureProvider, error) {
+	if azureConfig.Endpoint == "" {
+		azureConfig.Endpoint = defaultAzureEndpoint
+	}
+	if azureConfig.MetadataEndpoint == "" {
+		azureConfig.MetadataEndpoint = defaultAzureMetadataEndpoint
+	}
+	if azureConfig.Timeout == 0 {
+		azureConfig.Timeout = 10 * time.Second
+	}
+	azureConfig.Endpoint = strings.TrimSuffix(azureConfig.Endpoint, "/")
+	azureConfig.MetadataEndpoint = strings.TrimSuffix(azureConfig.MetadataEndpoint, "/")
+
+	klog.Infof("Using ARM endpoint: %s", azureConfig.Endpoint)
+	return &AzureProvider{
+		config: azureConfig,
+		client: &http.Client{Timeout: azureConfig.Timeout},
+	}, nil
+}
+
+func parseAzureProviderID(providerID string) (*azureVM, error) {
+	if m := azureVMRegexp.FindStringSubmatch(providerID); m != nil {
+		return &azureVM{Subscription: m[1], ResourceGroup: m[2], Name: m[3]}, nil
+	}
+	if m := azureVMSSRegexp.FindStringSubmatch(providerID); m != nil {
+		return &azureVM{Subscription: m[1], ResourceGroup: m[2], ScaleSet: m[3], Name: m[4]}, nil
+	}
+	return nil, fmt.Errorf("not an azure vm ProviderID: %q", providerID)
+}
+
+// InstanceID returns the resource path of the VM backing the node.
+func (p *AzureProvider) InstanceID(node *corev1.Node) (string, error) {
+	vm, err := parseAzureProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return "", fmt.Errorf("node [%s]: %s", node.GetName(), err.Error())
+	}
+	return vm.resourcePath(), nil
+}
+
+func (p *AzureProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: false}
+}
+
+func (p *AzureProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	if len(nodes) == 0 {
+		return tags, nil
+	}
+
+	token, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, no := range nodes {
+		vm, err := parseAzureProviderID(no.Spec.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("node [%s]: %s", no.GetName(), err.Error())
+		}
+
+		// VMSS instances inherit tags of their scale set, instance tags take precedence
+		merged := map[string]string{}
+		if vm.ScaleSet != "" {
+			scaleSet, err := p.getResource(vm.scaleSetPath(), token)
+			if err != nil {
+				return nil, err
+			}
+			for k, v := range scaleSet.Tags {
+				merged[k] = v
+			}
+		}
+		instance, err := p.getResource(vm.resourcePath(), token)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range instance.Tags {
+			merged[k] = v
+		}
+
+		tags[vm.resourcePath()] = p.toTags(merged)
+	}
+	return tags, nil
+}
+
+func (p *AzureProvider) toTags(azureTags map[string]string) []*Tag {
+	result := make([]*Tag, 0)
+	for key, value := range azureTags {
+		if !strings.HasPrefix(key, p.config.TagKeyPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, p.config.TagKeyPrefix)
+		result = append(result, &Tag{Key: p.config.TagPrefix + key, Value: value})
+	}
+	return result
+}
+
+func (p *AzureProvider) getResource(path, token string) (*azureResource, error) {
+	req, err := http.NewRequest(http.MethodGet, p.config.Endpoint+path+"?api-version="+azureAPIVersion, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resource := &azureResource{}
+	if err := doJSON(p.client, req, resource); err != nil {
+		return nil, fmt.Errorf("can not get azure resource %s: %s", path, err.Error())
+	}
+	return resource, nil
+}
+
+// token returns an access token of the managed identity of the VM we run on
+func (p *AzureProvider) token() (string, error) {
+	query := url.Values{}
+	query.Set("api-version", "2018-02-01")
+	query.Set("resource", defaultAzureEndpoint+"/")
+	req, err := http.NewRequest(http.MethodGet, p.config.MetadataEndpoint+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata", "true")
+
+	token := &azureToken{}
+	if err := doJSON(p.client, req, token); err != nil {
+		return "", fmt.Errorf("can not get azure access token: %s", err.Error())
+	}
+	return token.AccessToken, nil
+}
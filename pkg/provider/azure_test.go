@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseAzureProviderID(t *testing.T) {
+	vm, err := parseAzureProviderID("azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1")
+	assert.NoError(t, err)
+	assert.Equal(t, &azureVM{Subscription: "sub", ResourceGroup: "rg", Name: "vm-1"}, vm)
+
+	vm, err = parseAzureProviderID("azure:///subscriptions/sub/resourcegroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3")
+	assert.NoError(t, err)
+	assert.Equal(t, &azureVM{Subscription: "sub", ResourceGroup: "rg", ScaleSet: "pool", Name: "3"}, vm)
+
+	_, err = parseAzureProviderID("azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/networkInterfaces/nic")
+	assert.Error(t, err)
+}
+
+func TestAzureListTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata/identity/oauth2/token":
+			assert.Equal(t, "true", r.Header.Get("Metadata"))
+			fmt.Fprint(w, `{"access_token": "secret"}`)
+		case "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1":
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{"tags": {"t2l-role": "worker", "unrelated": "x"}}`)
+		case "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool":
+			fmt.Fprint(w, `{"tags": {"t2l-role": "pool", "t2l-team": "infra"}}`)
+		case "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3":
+			fmt.Fprint(w, `{"tags": {"t2l-role": "instance"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p, _ := NewAzureProvider(AzureConfig{
+		Endpoint:         server.URL,
+		MetadataEndpoint: server.URL,
+		TagKeyPrefix:     "t2l-",
+		TagPrefix:        "devops.apixio.com/",
+	})
+
+	tags, err := p.ListTags([]*corev1.Node{
+		newNode("vm-1", "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"),
+		newNode("pool-3", "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3"),
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+	}, tags["/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm-1"])
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "instance"},
+		{Key: "devops.apixio.com/team", Value: "infra"},
+	}, tags["/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachineScaleSets/pool/virtualMachines/3"])
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var cacheLookups = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tag_to_label",
+		Subsystem: "provider_cache",
+		Name:      "lookups_total",
+		Help:      "Tag lookups of instances by result: hit, negative_hit or miss.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheLookups)
+}
+
+// CacheConfig contains configuration to create a new cached provider.
+type CacheConfig struct {
+	// TTL of tags of an instance
+	TTL time.Duration
+	// NegativeTTL of instances the provider returned no tags for
+	NegativeTTL time.Duration
+}
+
+// CachedProvider keeps tags returned by another provider keyed by instance id,
+// so the worker and the periodic checker share lookups.
+type CachedProvider struct {
+	provider Provider
+	config   CacheConfig
+	now      func() time.Time
+
+	lock      sync.Mutex
+	entries   map[string]*cacheEntry
+	lastSweep time.Time
+}
+
+type cacheEntry struct {
+	tags   []*Tag
+	found  bool
+	expire time.Time
+}
+
+func NewCachedProvider(p Provider, cacheConfig CacheConfig) *CachedProvider {
+	return &CachedProvider{
+		provider: p,
+		config:   cacheConfig,
+		now:      time.Now,
+		entries:  map[string]*cacheEntry{},
+	}
+}
+
+func (c *CachedProvider) InstanceID(node *corev1.Node) (string, error) {
+	return c.provider.InstanceID(node)
+}
+
+func (c *CachedProvider) Capabilities() Capabilities {
+	return c.provider.Capabilities()
+}
+
+func (c *CachedProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	var missing []*corev1.Node
+	var missingIds []string
+	for _, no := range nodes {
+		id, err := c.provider.InstanceID(no)
+		if err != nil {
+			return nil, err
+		}
+		entry := c.get(id)
+		if entry == nil {
+			cacheLookups.WithLabelValues("miss").Inc()
+			missing = append(missing, no)
+			missingIds = append(missingIds, id)
+			continue
+		}
+		if !entry.found {
+			cacheLookups.WithLabelValues("negative_hit").Inc()
+			continue
+		}
+		cacheLookups.WithLabelValues("hit").Inc()
+		tags[id] = entry.tags
+	}
+
+	if len(missing) == 0 {
+		return tags, nil
+	}
+
+	fetched, err := c.provider.ListTags(missing)
+	partial, isPartial := err.(*PartialError)
+	if err != nil && !isPartial {
+		return nil, err
+	}
+	failed := partial.failedSet()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	for _, id := range missingIds {
+		if failed[id] {
+			continue
+		}
+		if t, ok := fetched[id]; ok {
+			c.entries[id] = &cacheEntry{tags: t, found: true, expire: now.Add(c.config.TTL)}
+			tags[id] = t
+		} else {
+			c.entries[id] = &cacheEntry{expire: now.Add(c.config.NegativeTTL)}
+		}
+	}
+	c.sweep(now)
+	if isPartial {
+		return tags, partial
+	}
+	return tags, nil
+}
+
+// Invalidate drops cached tags of the given instances.
+func (c *CachedProvider) Invalidate(ids ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, id := range ids {
+		delete(c.entries, id)
+	}
+}
+
+// Watch forwards changes of the wrapped provider and invalidates the nodes the controller matches.
+func (c *CachedProvider) Watch(stopCh <-chan struct{}, changed func(NodeMatcher)) {
+	watcher, ok := c.provider.(Watcher)
+	if !ok {
+		<-stopCh
+		return
+	}
+	watcher.Watch(stopCh, func(match NodeMatcher) {
+		changed(func(node *corev1.Node) bool {
+			if !match(node) {
+				return false
+			}
+			if id, err := c.provider.InstanceID(node); err == nil {
+				c.Invalidate(id)
+			}
+			return true
+		})
+	})
+}
+
+func (c *CachedProvider) get(id string) *cacheEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[id]
+	if !ok || c.now().After(entry.expire) {
+		return nil
+	}
+	return entry
+}
+
+// sweep drops expired entries of instances nobody asks for anymore, callers hold the lock
+func (c *CachedProvider) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < c.config.TTL {
+		return
+	}
+	c.lastSweep = now
+	for id, entry := range c.entries {
+		if now.After(entry.expire) {
+			delete(c.entries, id)
+		}
+	}
+}
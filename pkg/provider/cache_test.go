@@ -0,0 +1,125 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// countingProvider returns tags keyed by node name and records which nodes were looked up
+type countingProvider struct {
+	tags    map[string][]*Tag
+	lookups []string
+}
+
+func (f *countingProvider) InstanceID(node *corev1.Node) (string, error) {
+	return node.GetName(), nil
+}
+
+func (f *countingProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	result := map[string][]*Tag{}
+	for _, no := range nodes {
+		f.lookups = append(f.lookups, no.GetName())
+		if t, ok := f.tags[no.GetName()]; ok {
+			result[no.GetName()] = t
+		}
+	}
+	return result, nil
+}
+
+func (f *countingProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: true}
+}
+
+func TestCachedProvider(t *testing.T) {
+	inner := &countingProvider{tags: map[string][]*Tag{
+		"node-1": {{Key: "k", Value: "v"}},
+	}}
+	now := time.Now()
+	c := NewCachedProvider(inner, CacheConfig{TTL: time.Minute, NegativeTTL: 10 * time.Second})
+	c.now = func() time.Time { return now }
+
+	node1, node2 := newNode("node-1", ""), newNode("node-2", "")
+
+	tags, err := c.ListTags([]*corev1.Node{node1, node2})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]*Tag{"node-1": {{Key: "k", Value: "v"}}}, tags)
+	assert.Equal(t, []string{"node-1", "node-2"}, inner.lookups)
+
+	// both positive and negative results are served from the cache
+	tags, _ = c.ListTags([]*corev1.Node{node1, node2})
+	assert.Equal(t, map[string][]*Tag{"node-1": {{Key: "k", Value: "v"}}}, tags)
+	assert.Len(t, inner.lookups, 2)
+
+	// negative entries expire first
+	now = now.Add(20 * time.Second)
+	c.ListTags([]*corev1.Node{node1, node2})
+	assert.Equal(t, []string{"node-1", "node-2", "node-2"}, inner.lookups)
+
+	c.Invalidate("node-1")
+	c.ListTags([]*corev1.Node{node1})
+	assert.Equal(t, []string{"node-1", "node-2", "node-2", "node-1"}, inner.lookups)
+
+	now = now.Add(2 * time.Minute)
+	c.ListTags([]*corev1.Node{node1})
+	assert.Len(t, inner.lookups, 5)
+}
+
+type watchingProvider struct {
+	countingProvider
+}
+
+func (w *watchingProvider) Watch(stopCh <-chan struct{}, changed func(NodeMatcher)) {
+	changed(func(node *corev1.Node) bool { return node.GetName() == "node-1" })
+}
+
+func TestCachedProviderWatch(t *testing.T) {
+	inner := &watchingProvider{countingProvider{tags: map[string][]*Tag{}}}
+	c := NewCachedProvider(inner, CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute})
+	node1, node2 := newNode("node-1", ""), newNode("node-2", "")
+	c.ListTags([]*corev1.Node{node1, node2})
+
+	var matched []string
+	c.Watch(nil, func(match NodeMatcher) {
+		for _, no := range []*corev1.Node{node1, node2} {
+			if match(no) {
+				matched = append(matched, no.GetName())
+			}
+		}
+	})
+	assert.Equal(t, []string{"node-1"}, matched)
+
+	// only the changed node is looked up again
+	c.ListTags([]*corev1.Node{node1, node2})
+	assert.Equal(t, []string{"node-1", "node-2", "node-1"}, inner.lookups)
+}
+
+type partialProvider struct {
+	countingProvider
+}
+
+func (f *partialProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags, _ := f.countingProvider.ListTags(nodes)
+	delete(tags, "node-2")
+	return tags, &PartialError{Failed: []string{"node-2"}, Err: fmt.Errorf("boom")}
+}
+
+func TestCachedProviderPartialError(t *testing.T) {
+	inner := &partialProvider{countingProvider{tags: map[string][]*Tag{
+		"node-1": {{Key: "k", Value: "v"}},
+		"node-2": {{Key: "k", Value: "v"}},
+	}}}
+	c := NewCachedProvider(inner, CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute})
+	node1, node2 := newNode("node-1", ""), newNode("node-2", "")
+
+	tags, err := c.ListTags([]*corev1.Node{node1, node2})
+	assert.IsType(t, &PartialError{}, err)
+	assert.Equal(t, map[string][]*Tag{"node-1": {{Key: "k", Value: "v"}}}, tags)
+
+	// the failed instance is not cached as an instance without tags
+	c.ListTags([]*corev1.Node{node1, node2})
+	assert.Equal(t, []string{"node-1", "node-2", "node-2"}, inner.lookups)
+}
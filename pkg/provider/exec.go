@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const execAPIVersion = "tag-to-label/v1"
+
+// ExecConfig contains configuration to create a new exec plugin provider.
+type ExecConfig struct {
+	Command string
+	Args    []string
+	// Timeout of a single plugin call
+	Timeout time.Duration
+}
+
+// ExecRequest is written as JSON to the stdin of the plugin.
+type ExecRequest struct {
+	APIVersion string            `json:"apiVersion"`
+	NodeName   string            `json:"nodeName"`
+	ProviderID string            `json:"providerID"`
+	Labels     map[string]string `json:"labels"`
+}
+
+// ExecResponse is read as JSON from the stdout of the plugin.
+// A plugin reports a failure with a non zero exit code or by setting Error.
+type ExecResponse struct {
+	Tags  map[string]string `json:"tags"`
+	Error string            `json:"error,omitempty"`
+}
+
+// ExecProvider asks an external binary for the tags of a node.
+// Responses are cached by the controller like those of every other provider.
+type ExecProvider struct {
+	config ExecConfig
+}
+
+func NewExecProvider(execConfig ExecConfig) (*ExecProvider, error) {
+	if execConfig.Command == "" {
+		return nil, fmt.Errorf("exec provider needs a command")
+	}
+	if _, err := exec.LookPath(execConfig.Command); err != nil {
+		return nil, err
+	}
+	if execConfig.Timeout == 0 {
+		execConfig.Timeout = 10 * time.Second
+	}
+	klog.Infof("Using exec plugin: %s %s", execConfig.Command, strings.Join(execConfig.Args, " "))
+	return &ExecProvider{config: execConfig}, nil
+}
+
+// InstanceID returns the node name, the plugin is called per node.
+func (p *ExecProvider) InstanceID(node *corev1.Node) (string, error) {
+	return node.GetName(), nil
+}
+
+func (p *ExecProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: false}
+}
+
+func (p *ExecProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	for _, no := range nodes {
+		t, err := p.call(no)
+		if err != nil {
+			return nil, fmt.Errorf("exec plugin failed for node [%s]: %s", no.GetName(), err.Error())
+		}
+		tags[no.GetName()] = t
+	}
+	return tags, nil
+}
+
+func (p *ExecProvider) call(node *corev1.Node) ([]*Tag, error) {
+	request, err := json.Marshal(&ExecRequest{
+		APIVersion: execAPIVersion,
+		NodeName:   node.GetName(),
+		ProviderID: node.Spec.ProviderID,
+		Labels:     node.GetLabels(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.config.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.config.Command, p.config.Args...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Wait also waits for children of the plugin holding stdout open, do not let them outlive the timeout
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("timed out after %s", p.config.Timeout)
+			}
+			return nil, fmt.Errorf("%s: %s", err.Error(), strings.TrimSpace(stderr.String()))
+		}
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out after %s", p.config.Timeout)
+	}
+
+	response := &ExecResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return nil, fmt.Errorf("invalid response: %s", err.Error())
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	return toTagList(response.Tags), nil
+}
@@ -0,0 +1,63 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func newShellProvider(t *testing.T, script string, config ExecConfig) *ExecProvider {
+	config.Command = "sh"
+	config.Args = []string{"-c", script}
+	p, err := NewExecProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestExecListTags(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "exec")
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+
+	// echo the request back so the test can check what the plugin received
+	p := NewCachedProvider(newShellProvider(t, `echo x >> `+calls+`; req=$(cat); echo "{\"tags\": {\"devops.apixio.com/request\": $(echo "$req" | sed 's/"/\\"/g' | sed 's/^/"/;s/$/"/')}}"`,
+		ExecConfig{}), CacheConfig{TTL: time.Minute})
+
+	node := newNode("node-1", "metal:///rack1/node-1")
+	node.Labels = map[string]string{"zone": "a"}
+
+	for i := 0; i < 2; i++ {
+		tags, err := p.ListTags([]*corev1.Node{node})
+		assert.NoError(t, err)
+		assert.Len(t, tags["node-1"], 1)
+		assert.Equal(t, "devops.apixio.com/request", tags["node-1"][0].Key)
+		assert.JSONEq(t, `{"apiVersion": "tag-to-label/v1", "nodeName": "node-1", "providerID": "metal:///rack1/node-1", "labels": {"zone": "a"}}`, tags["node-1"][0].Value)
+	}
+
+	raw, _ := ioutil.ReadFile(calls)
+	assert.Equal(t, 1, strings.Count(string(raw), "x"), "second lookup should be cached")
+}
+
+func TestExecErrors(t *testing.T) {
+	node := newNode("node-1", "")
+
+	_, err := newShellProvider(t, `echo '{"error": "unknown machine"}'`, ExecConfig{}).ListTags([]*corev1.Node{node})
+	assert.EqualError(t, err, "exec plugin failed for node [node-1]: unknown machine")
+
+	_, err = newShellProvider(t, `echo boom >&2; exit 3`, ExecConfig{}).ListTags([]*corev1.Node{node})
+	assert.EqualError(t, err, "exec plugin failed for node [node-1]: exit status 3: boom")
+
+	_, err = newShellProvider(t, `echo not json`, ExecConfig{}).ListTags([]*corev1.Node{node})
+	assert.Error(t, err)
+
+	_, err = newShellProvider(t, `sleep 5`, ExecConfig{Timeout: 100 * time.Millisecond}).ListTags([]*corev1.Node{node})
+	assert.EqualError(t, err, "exec plugin failed for node [node-1]: timed out after 100ms")
+}
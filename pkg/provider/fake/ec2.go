@@ -0,0 +1,349 @@
+// Package fake provides an in-process EC2 API for tests, point the aws provider at it with AWSConfig.Endpoint.
+package fake
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	ec2Namespace = "http://ec2.amazonaws.com/doc/2016-11-15/"
+	// defaultPageSize is the page size when neither the fake nor the request sets one
+	defaultPageSize = 1000
+)
+
+// Instance is an instance known to the fake.
+type Instance struct {
+	ID           string
+	Zone         string
+	InstanceType string
+	ImageID      string
+	Tags         map[string]string
+}
+
+// EC2 serves DescribeTags and DescribeInstances of the EC2 query API over HTTP.
+// Results are paginated, and throttling and errors can be injected per action.
+type EC2 struct {
+	server *httptest.Server
+
+	lock      sync.Mutex
+	instances map[string]*Instance
+	pageSize  int
+	throttle  int
+	failures  map[string][]string
+	calls     map[string]int
+}
+
+// NewEC2 starts a fake, Close stops it.
+func NewEC2() *EC2 {
+	f := &EC2{
+		instances: map[string]*Instance{},
+		failures:  map[string][]string{},
+		calls:     map[string]int{},
+	}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// URL is the endpoint of the fake.
+func (f *EC2) URL() string {
+	return f.server.URL
+}
+
+func (f *EC2) Close() {
+	f.server.Close()
+}
+
+// AddInstance adds or replaces an instance.
+func (f *EC2) AddInstance(instance Instance) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if instance.Tags == nil {
+		instance.Tags = map[string]string{}
+	}
+	f.instances[instance.ID] = &instance
+}
+
+// SetTags replaces the tags of an instance.
+func (f *EC2) SetTags(id string, tags map[string]string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if instance, ok := f.instances[id]; ok {
+		instance.Tags = tags
+	}
+}
+
+// SetPageSize limits the results of every page, MaxResults of a request is honoured when smaller.
+func (f *EC2) SetPageSize(size int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pageSize = size
+}
+
+// Throttle answers the next n calls with RequestLimitExceeded.
+func (f *EC2) Throttle(n int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.throttle = n
+}
+
+// Fail answers the next calls of action with the error codes in turn, e.g. Fail("DescribeTags", "UnauthorizedOperation").
+func (f *EC2) Fail(action string, codes ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failures[action] = append(f.failures[action], codes...)
+}
+
+// Calls returns how often action was called, failed calls included.
+func (f *EC2) Calls(action string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[action]
+}
+
+func (f *EC2) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedQueryString", err.Error())
+		return
+	}
+	action := r.PostForm.Get("Action")
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls[action]++
+	if f.throttle > 0 {
+		f.throttle--
+		writeError(w, http.StatusServiceUnavailable, "RequestLimitExceeded", "Request limit exceeded.")
+		return
+	}
+	if codes := f.failures[action]; len(codes) > 0 {
+		f.failures[action] = codes[1:]
+		writeError(w, http.StatusBadRequest, codes[0], "injected by fake")
+		return
+	}
+
+	switch action {
+	case "DescribeTags":
+		f.describeTags(w, r.PostForm)
+	case "DescribeInstances":
+		f.describeInstances(w, r.PostForm)
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("The action %s is not valid for this web service.", action))
+	}
+}
+
+type tagItem struct {
+	ResourceID   string `xml:"resourceId,omitempty"`
+	ResourceType string `xml:"resourceType,omitempty"`
+	Key          string `xml:"key"`
+	Value        string `xml:"value"`
+}
+
+type describeTagsResponse struct {
+	XMLName   xml.Name  `xml:"DescribeTagsResponse"`
+	Namespace string    `xml:"xmlns,attr"`
+	RequestID string    `xml:"requestId"`
+	Tags      []tagItem `xml:"tagSet>item"`
+	NextToken string    `xml:"nextToken,omitempty"`
+}
+
+func (f *EC2) describeTags(w http.ResponseWriter, form url.Values) {
+	filters := parseFilters(form)
+	var items []tagItem
+	for _, instance := range f.sortedInstances() {
+		if !filters.matches("resource-id", instance.ID) {
+			continue
+		}
+		for _, key := range sortedKeys(instance.Tags) {
+			if filters.matches("key", key) {
+				items = append(items, tagItem{ResourceID: instance.ID, ResourceType: "instance", Key: key, Value: instance.Tags[key]})
+			}
+		}
+	}
+
+	start, end, next, err := f.page(form, len(items))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+	writeXML(w, describeTagsResponse{Namespace: ec2Namespace, RequestID: "fake", Tags: items[start:end], NextToken: next})
+}
+
+type instanceItem struct {
+	InstanceID   string    `xml:"instanceId"`
+	InstanceType string    `xml:"instanceType,omitempty"`
+	ImageID      string    `xml:"imageId,omitempty"`
+	Zone         string    `xml:"placement>availabilityZone,omitempty"`
+	Tags         []tagItem `xml:"tagSet>item"`
+}
+
+type reservationItem struct {
+	ReservationID string         `xml:"reservationId"`
+	Instances     []instanceItem `xml:"instancesSet>item"`
+}
+
+type describeInstancesResponse struct {
+	XMLName      xml.Name          `xml:"DescribeInstancesResponse"`
+	Namespace    string            `xml:"xmlns,attr"`
+	RequestID    string            `xml:"requestId"`
+	Reservations []reservationItem `xml:"reservationSet>item"`
+	NextToken    string            `xml:"nextToken,omitempty"`
+}
+
+func (f *EC2) describeInstances(w http.ResponseWriter, form url.Values) {
+	filters := parseFilters(form)
+	ids := listValues(form, "InstanceId")
+	// like EC2, unknown ids fail the call while unknown ids of a filter are left out
+	for _, id := range ids {
+		if _, ok := f.instances[id]; !ok {
+			writeError(w, http.StatusBadRequest, "InvalidInstanceID.NotFound", fmt.Sprintf("The instance ID '%s' does not exist", id))
+			return
+		}
+	}
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var items []instanceItem
+	for _, instance := range f.sortedInstances() {
+		if len(ids) > 0 && !wanted[instance.ID] || !filters.matches("instance-id", instance.ID) {
+			continue
+		}
+		item := instanceItem{InstanceID: instance.ID, InstanceType: instance.InstanceType, ImageID: instance.ImageID, Zone: instance.Zone}
+		for _, key := range sortedKeys(instance.Tags) {
+			item.Tags = append(item.Tags, tagItem{Key: key, Value: instance.Tags[key]})
+		}
+		items = append(items, item)
+	}
+
+	start, end, next, err := f.page(form, len(items))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidParameterValue", err.Error())
+		return
+	}
+	response := describeInstancesResponse{Namespace: ec2Namespace, RequestID: "fake", NextToken: next}
+	for _, item := range items[start:end] {
+		response.Reservations = append(response.Reservations, reservationItem{ReservationID: "r-" + item.InstanceID, Instances: []instanceItem{item}})
+	}
+	writeXML(w, response)
+}
+
+// page returns the range of results of the request and the token of the next page, callers hold the lock
+func (f *EC2) page(form url.Values, total int) (start, end int, next string, err error) {
+	if token := form.Get("NextToken"); token != "" {
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > total {
+			return 0, 0, "", fmt.Errorf("invalid NextToken %q", token)
+		}
+	}
+	size := f.pageSize
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if max, err := strconv.Atoi(form.Get("MaxResults")); err == nil && max > 0 && max < size {
+		size = max
+	}
+	end = start + size
+	if end >= total {
+		return start, total, "", nil
+	}
+	return start, end, strconv.Itoa(end), nil
+}
+
+func (f *EC2) sortedInstances() []*Instance {
+	var result []*Instance
+	for _, instance := range f.instances {
+		result = append(result, instance)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// filters are the Filter.N.Name and Filter.N.Value.M parameters of a request by name
+type filters map[string][]*regexp.Regexp
+
+func parseFilters(form url.Values) filters {
+	result := filters{}
+	for i := 1; ; i++ {
+		name := form.Get(fmt.Sprintf("Filter.%d.Name", i))
+		if name == "" {
+			return result
+		}
+		for _, value := range listValues(form, fmt.Sprintf("Filter.%d.Value", i)) {
+			result[name] = append(result[name], wildcard(value))
+		}
+	}
+}
+
+// matches reports whether value passes the filter name, a missing filter passes everything
+func (f filters) matches(name, value string) bool {
+	patterns, ok := f[name]
+	if !ok {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard turns an EC2 filter value with * and ? into a regexp
+func wildcard(value string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(value)
+	pattern = strings.Replace(pattern, `\*`, ".*", -1)
+	pattern = strings.Replace(pattern, `\?`, ".", -1)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// listValues returns the values of a list parameter like InstanceId.1, InstanceId.2
+func listValues(form url.Values, name string) []string {
+	var values []string
+	for i := 1; ; i++ {
+		value, ok := form[fmt.Sprintf("%s.%d", name, i)]
+		if !ok {
+			return values
+		}
+		values = append(values, value[0])
+	}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Response"`
+	Code      string   `xml:"Errors>Error>Code"`
+	Message   string   `xml:"Errors>Error>Message"`
+	RequestID string   `xml:"RequestID"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeXMLStatus(w, status, errorResponse{Code: code, Message: message, RequestID: "fake"})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	writeXMLStatus(w, http.StatusOK, v)
+}
+
+func writeXMLStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,89 @@
+package fake
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(f *EC2) *ec2.EC2 {
+	return ec2.New(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-west-2"),
+		Endpoint:    aws.String(f.URL()),
+		Credentials: credentials.NewStaticCredentials("KEY", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})))
+}
+
+func TestDescribeTagsPages(t *testing.T) {
+	f := NewEC2()
+	defer f.Close()
+	f.AddInstance(Instance{ID: "i-1", Tags: map[string]string{"devops.apixio.com/role": "worker", "Name": "node-1"}})
+	f.AddInstance(Instance{ID: "i-2", Tags: map[string]string{"devops.apixio.com/role": "infra", "devops.apixio.com/pool": "blue"}})
+	f.AddInstance(Instance{ID: "i-3", Tags: map[string]string{"devops.apixio.com/role": "other"}})
+	f.SetPageSize(2)
+
+	var tags []string
+	err := newClient(f).DescribeTagsPages(&ec2.DescribeTagsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("resource-id"), Values: aws.StringSlice([]string{"i-1", "i-2"})},
+			{Name: aws.String("key"), Values: aws.StringSlice([]string{"devops.apixio.com/*"})},
+		},
+	}, func(output *ec2.DescribeTagsOutput, last bool) bool {
+		for _, tag := range output.Tags {
+			tags = append(tags, aws.StringValue(tag.ResourceId)+":"+aws.StringValue(tag.Key)+"="+aws.StringValue(tag.Value))
+		}
+		return true
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"i-1:devops.apixio.com/role=worker",
+		"i-2:devops.apixio.com/pool=blue",
+		"i-2:devops.apixio.com/role=infra",
+	}, tags)
+	assert.Equal(t, 2, f.Calls("DescribeTags"))
+}
+
+func TestDescribeInstances(t *testing.T) {
+	f := NewEC2()
+	defer f.Close()
+	f.AddInstance(Instance{ID: "i-1", Zone: "us-west-2c", InstanceType: "m5.large", Tags: map[string]string{"Name": "node-1"}})
+	client := newClient(f)
+
+	output, err := client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-1"})})
+	assert.NoError(t, err)
+	instance := output.Reservations[0].Instances[0]
+	assert.Equal(t, "m5.large", aws.StringValue(instance.InstanceType))
+	assert.Equal(t, "us-west-2c", aws.StringValue(instance.Placement.AvailabilityZone))
+	assert.Equal(t, "node-1", aws.StringValue(instance.Tags[0].Value))
+
+	_, err = client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-1", "i-2"})})
+	assert.Equal(t, "InvalidInstanceID.NotFound", err.(awserr.Error).Code())
+
+	output, err = client.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: aws.StringSlice([]string{"i-1", "i-2"})}},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, output.Reservations, 1)
+}
+
+func TestInjectedErrors(t *testing.T) {
+	f := NewEC2()
+	defer f.Close()
+	client := newClient(f)
+
+	f.Throttle(1)
+	f.Fail("DescribeTags", "UnauthorizedOperation")
+	_, err := client.DescribeTags(&ec2.DescribeTagsInput{})
+	assert.Equal(t, "RequestLimitExceeded", err.(awserr.Error).Code())
+	_, err = client.DescribeTags(&ec2.DescribeTagsInput{})
+	assert.Equal(t, "UnauthorizedOperation", err.(awserr.Error).Code())
+	_, err = client.DescribeTags(&ec2.DescribeTagsInput{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, f.Calls("DescribeTags"))
+}
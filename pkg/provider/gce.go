@@ -0,0 +1,187 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	defaultGCEEndpoint         = "https://compute.googleapis.com"
+	defaultGCEMetadataEndpoint = "http://metadata.google.internal"
+)
+
+// GCEConfig contains configuration to create a new GCE provider.
+type GCEConfig struct {
+	// Endpoint of the Compute API, override it to test against a local server
+	Endpoint string
+	// MetadataEndpoint is used to get an access token of the instance service account
+	MetadataEndpoint string
+	// MetadataKeys are instance metadata keys exposed as tags next to instance labels
+	MetadataKeys []string
+	// LabelPrefix limits exposed instance labels to the ones starting with it, the prefix is stripped
+	LabelPrefix string
+	// TagPrefix is prepended to every key, GCE label keys can not contain the tag prefix itself
+	TagPrefix string
+	Timeout   time.Duration
+}
+
+// GCEProvider reads instance labels and metadata through the Compute API.
+type GCEProvider struct {
+	config GCEConfig
+	client *http.Client
+}
+
+type gceInstance struct {
+	Labels   map[string]string `json:"labels"`
+	Metadata struct {
+		Items []struct {
+			Key   string `json:"key"`
+			Value string `json:"value"`
+		} `json:"items"`
+	} `json:"metadata"`
+}
+
+type gceToken struct {
+	AccessToken string `json:"access_token"`
+}
+
+func NewGCEProvider(gceConfig GCEConfig) (*GCEProvider, error) {
+	if gceConfig.Endpoint == "" {
+		gceConfig.Endpoint = defaultGCEEndpoint
+	}
+	if gceConfig.MetadataEndpoint == "" {
+		gceConfig.MetadataEndpoint = defaultGCEMetadataEndpoint
+	}
+	if gceConfig.Timeout == 0 {
+		gceConfig.Timeout = 10 * time.Second
+	}
+	gceConfig.Endpoint = strings.TrimSuffix(gceConfig.Endpoint, "/")
+	gceConfig.MetadataEndpoint = strings.TrimSuffix(gceConfig.MetadataEndpoint, "/")
+
+	klog.Infof("Using Compute API endpoint: %s", gceConfig.Endpoint)
+	return &GCEProvider{
+		config: gceConfig,
+		client: &http.Client{Timeout: gceConfig.Timeout},
+	}, nil
+}
+
+// parseGCEProviderID splits a ProviderID like gce://project/zone/instance
+func parseGCEProviderID(providerID string) (project, zone, instance string, err error) {
+	if !strings.HasPrefix(providerID, "gce://") {
+		return "", "", "", fmt.Errorf("not a gce ProviderID: %q", providerID)
+	}
+	parts := strings.Split(strings.TrimPrefix(providerID, "gce://"), "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("malformed gce ProviderID: %q", providerID)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// InstanceID returns project/zone/instance of a node.
+func (p *GCEProvider) InstanceID(node *corev1.Node) (string, error) {
+	project, zone, instance, err := parseGCEProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return "", fmt.Errorf("node [%s]: %s", node.GetName(), err.Error())
+	}
+	return strings.Join([]string{project, zone, instance}, "/"), nil
+}
+
+func (p *GCEProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: false}
+}
+
+func (p *GCEProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	tags := make(map[string][]*Tag)
+	if len(nodes) == 0 {
+		return tags, nil
+	}
+
+	token, err := p.token()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, no := range nodes {
+		id, err := p.InstanceID(no)
+		if err != nil {
+			return nil, err
+		}
+		instance, err := p.getInstance(id, token)
+		if err != nil {
+			return nil, err
+		}
+		tags[id] = p.instanceTags(instance)
+	}
+	return tags, nil
+}
+
+func (p *GCEProvider) instanceTags(instance *gceInstance) []*Tag {
+	result := make([]*Tag, 0)
+	for key, value := range instance.Labels {
+		if !strings.HasPrefix(key, p.config.LabelPrefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, p.config.LabelPrefix)
+		result = append(result, &Tag{Key: p.config.TagPrefix + key, Value: value})
+	}
+	for _, item := range instance.Metadata.Items {
+		for _, key := range p.config.MetadataKeys {
+			if item.Key == key {
+				result = append(result, &Tag{Key: p.config.TagPrefix + key, Value: item.Value})
+			}
+		}
+	}
+	return result
+}
+
+func (p *GCEProvider) getInstance(id, token string) (*gceInstance, error) {
+	parts := strings.Split(id, "/")
+	url := fmt.Sprintf("%s/compute/v1/projects/%s/zones/%s/instances/%s", p.config.Endpoint, parts[0], parts[1], parts[2])
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	instance := &gceInstance{}
+	if err := doJSON(p.client, req, instance); err != nil {
+		return nil, fmt.Errorf("can not get gce instance %s: %s", id, err.Error())
+	}
+	return instance, nil
+}
+
+// token returns an access token of the service account attached to the instance we run on
+func (p *GCEProvider) token() (string, error) {
+	url := p.config.MetadataEndpoint + "/computeMetadata/v1/instance/service-accounts/default/token"
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	token := &gceToken{}
+	if err := doJSON(p.client, req, token); err != nil {
+		return "", fmt.Errorf("can not get gce access token: %s", err.Error())
+	}
+	return token.AccessToken, nil
+}
+
+// doJSON sends the request and decodes a successful JSON response into out
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseGCEProviderID(t *testing.T) {
+	project, zone, instance, err := parseGCEProviderID("gce://my-project/us-central1-a/node-1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my-project", "us-central1-a", "node-1"}, []string{project, zone, instance})
+
+	for _, id := range []string{"aws:///us-west-2c/i-1", "gce://my-project/node-1", "gce:///us-central1-a/node-1"} {
+		_, _, _, err := parseGCEProviderID(id)
+		assert.Error(t, err, id)
+	}
+}
+
+func TestGCEListTags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			fmt.Fprint(w, `{"access_token": "secret"}`)
+		case "/compute/v1/projects/my-project/zones/us-central1-a/instances/node-1":
+			assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `{
+				"labels": {"t2l_role": "worker", "unrelated": "x"},
+				"metadata": {"items": [{"key": "pool", "value": "blue"}, {"key": "startup-script", "value": "#!/bin/sh"}]}
+			}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p, _ := NewGCEProvider(GCEConfig{
+		Endpoint:         server.URL,
+		MetadataEndpoint: server.URL,
+		MetadataKeys:     []string{"pool"},
+		LabelPrefix:      "t2l_",
+		TagPrefix:        "devops.apixio.com/",
+	})
+
+	tags, err := p.ListTags([]*corev1.Node{newNode("node-1", "gce://my-project/us-central1-a/node-1")})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com/pool", Value: "blue"},
+	}, tags["my-project/us-central1-a/node-1"])
+
+	_, err = p.ListTags([]*corev1.Node{newNode("node-2", "gce://my-project/us-central1-a/node-2")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	// imdsTokenTTL is how long an IMDSv2 session token is valid, it is renewed a minute before
+	imdsTokenTTL = 6 * time.Hour
+)
+
+// IMDSConfig contains configuration to create a new IMDS provider.
+type IMDSConfig struct {
+	// Endpoint of the instance metadata service, override it to test against a local server
+	Endpoint string
+	Timeout  time.Duration
+	// TagKeyPrefix of tags in metadata is replaced by TagPrefix. Metadata only has tags when no key
+	// contains '/', so devops.apixio.com:role is read as devops.apixio.com/role. Default TagPrefix with ':'.
+	TagKeyPrefix string
+	TagPrefix    string
+}
+
+// IMDSProvider reads tags of the instance it runs on from the instance metadata service (IMDSv2),
+// so a replica on every node needs no EC2 permissions. The instance must allow tags in its metadata.
+type IMDSProvider struct {
+	config IMDSConfig
+	client *http.Client
+
+	lock        sync.Mutex
+	token       string
+	tokenExpire time.Time
+}
+
+func NewIMDSProvider(imdsConfig IMDSConfig) (*IMDSProvider, error) {
+	if imdsConfig.Endpoint == "" {
+		imdsConfig.Endpoint = defaultIMDSEndpoint
+	}
+	if imdsConfig.Timeout == 0 {
+		imdsConfig.Timeout = 10 * time.Second
+	}
+	imdsConfig.Endpoint = strings.TrimSuffix(imdsConfig.Endpoint, "/")
+	if imdsConfig.TagKeyPrefix == "" && imdsConfig.TagPrefix != "" {
+		imdsConfig.TagKeyPrefix = strings.TrimSuffix(imdsConfig.TagPrefix, "/") + ":"
+	}
+
+	klog.Infof("Using instance metadata endpoint: %s, tag prefix: %s", imdsConfig.Endpoint, imdsConfig.TagKeyPrefix)
+	return &IMDSProvider{
+		config: imdsConfig,
+		client: &http.Client{Timeout: imdsConfig.Timeout},
+	}, nil
+}
+
+// InstanceID returns the EC2 instance id of a node.
+func (p *IMDSProvider) InstanceID(node *corev1.Node) (string, error) {
+	return awsInstanceID(node)
+}
+
+func (p *IMDSProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: true}
+}
+
+// ListTags returns tags of the node of this instance, other nodes are left out.
+func (p *IMDSProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	own, err := p.get("meta-data/instance-id")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]*Tag)
+	for _, no := range nodes {
+		id, err := p.InstanceID(no)
+		if err != nil {
+			return nil, err
+		}
+		if id != own {
+			klog.Warningf("[imds] Node [%s] is not this instance %s, skipping it", no.GetName(), own)
+			continue
+		}
+		tags, err := p.InstanceTags()
+		if err != nil {
+			return nil, err
+		}
+		if len(tags) > 0 {
+			result[id] = tags
+		}
+	}
+	return result, nil
+}
+
+// InstanceTags returns the tags of this instance, keys with TagKeyPrefix are renamed to TagPrefix.
+func (p *IMDSProvider) InstanceTags() ([]*Tag, error) {
+	keys, err := p.get("meta-data/tags/instance")
+	if err != nil {
+		return nil, fmt.Errorf("%s, are tags allowed in instance metadata?", err.Error())
+	}
+
+	var tags []*Tag
+	for _, key := range strings.Split(keys, "\n") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		value, err := p.get("meta-data/tags/instance/" + key)
+		if err != nil {
+			return nil, err
+		}
+		if p.config.TagKeyPrefix != "" && strings.HasPrefix(key, p.config.TagKeyPrefix) {
+			key = p.config.TagPrefix + strings.TrimPrefix(key, p.config.TagKeyPrefix)
+		}
+		tags = append(tags, &Tag{Key: key, Value: value})
+	}
+	return tags, nil
+}
+
+// Placement returns the id, availability zone and region of this instance.
+func (p *IMDSProvider) Placement() (id, zone, region string, err error) {
+	if id, err = p.get("meta-data/instance-id"); err != nil {
+		return "", "", "", err
+	}
+	if zone, err = p.get("meta-data/placement/availability-zone"); err != nil {
+		return "", "", "", err
+	}
+	if region, err = p.get("meta-data/placement/region"); err != nil {
+		return "", "", "", err
+	}
+	return id, zone, region, nil
+}
+
+// get reads a metadata path like meta-data/instance-id
+func (p *IMDSProvider) get(path string) (string, error) {
+	token, err := p.sessionToken()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/latest/%s", p.config.Endpoint, path), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	return p.do(req)
+}
+
+// sessionToken returns the IMDSv2 token, a new one is requested before the current one expires
+func (p *IMDSProvider) sessionToken() (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.token != "" && time.Now().Before(p.tokenExpire) {
+		return p.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodPut, p.config.Endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", fmt.Sprintf("%d", int(imdsTokenTTL.Seconds())))
+	token, err := p.do(req)
+	if err != nil {
+		return "", fmt.Errorf("can not get metadata token: %s", err.Error())
+	}
+	p.token = token
+	p.tokenExpire = time.Now().Add(imdsTokenTTL - time.Minute)
+	return token, nil
+}
+
+func (p *IMDSProvider) do(req *http.Request) (string, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s of %s", resp.Status, req.URL.Path)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
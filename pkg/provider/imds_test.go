@@ -0,0 +1,101 @@
+package provider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newIMDSStub serves instance i-1 with tags, tags is nil when they are not allowed in metadata.
+// Like IMDS it serves no key with '/', such tags keep instances from allowing tags in metadata.
+func newIMDSStub(t *testing.T, tags map[string]string) (*httptest.Server, *int) {
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			assert.Equal(t, http.MethodPut, r.Method)
+			assert.Equal(t, "21600", r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+			tokens++
+			fmt.Fprint(w, "secret")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch path := r.URL.Path; {
+		case path == "/latest/meta-data/instance-id":
+			fmt.Fprint(w, "i-1")
+		case path == "/latest/meta-data/placement/availability-zone":
+			fmt.Fprint(w, "us-west-2c")
+		case path == "/latest/meta-data/placement/region":
+			fmt.Fprint(w, "us-west-2")
+		case path == "/latest/meta-data/tags/instance" && tags != nil:
+			for k := range tags {
+				assert.NotContains(t, k, "/", "imds does not allow the tag")
+				fmt.Fprintln(w, k)
+			}
+		case len(path) > len("/latest/meta-data/tags/instance/") && tags != nil:
+			value, ok := tags[path[len("/latest/meta-data/tags/instance/"):]]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, value)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server, &tokens
+}
+
+func TestIMDSListTags(t *testing.T) {
+	server, tokens := newIMDSStub(t, map[string]string{"devops.apixio.com:role": "worker", "Name": "node 1"})
+	defer server.Close()
+
+	p, _ := NewIMDSProvider(IMDSConfig{Endpoint: server.URL, TagPrefix: "devops.apixio.com/"})
+	nodes := []*corev1.Node{
+		newNode("node1", "aws:///us-west-2c/i-1"),
+		newNode("node2", "aws:///us-west-2c/i-2"),
+	}
+
+	for i := 0; i < 2; i++ {
+		tags, err := p.ListTags(nodes)
+		assert.NoError(t, err)
+		assert.Len(t, tags, 1, "only the node of this instance")
+		assert.ElementsMatch(t, []*Tag{
+			{Key: "devops.apixio.com/role", Value: "worker"},
+			{Key: "Name", Value: "node 1"},
+		}, tags["i-1"])
+	}
+	assert.Equal(t, 1, *tokens, "the token is reused")
+
+	id, zone, region, err := p.Placement()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"i-1", "us-west-2c", "us-west-2"}, []string{id, zone, region})
+}
+
+func TestIMDSTagsNotAllowed(t *testing.T) {
+	server, _ := newIMDSStub(t, nil)
+	defer server.Close()
+
+	p, _ := NewIMDSProvider(IMDSConfig{Endpoint: server.URL})
+	_, err := p.ListTags([]*corev1.Node{newNode("node1", "aws:///us-west-2c/i-1")})
+	assert.Error(t, err)
+}
+
+func TestIMDSTagKeyPrefix(t *testing.T) {
+	server, _ := newIMDSStub(t, map[string]string{"apixio:role": "worker", "devops.apixio.com:pool": "blue"})
+	defer server.Close()
+
+	p, _ := NewIMDSProvider(IMDSConfig{Endpoint: server.URL, TagKeyPrefix: "apixio:", TagPrefix: "devops.apixio.com/"})
+	tags, err := p.InstanceTags()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/role", Value: "worker"},
+		{Key: "devops.apixio.com:pool", Value: "blue"},
+	}, tags)
+}
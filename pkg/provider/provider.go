@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Provider is a source of tags for kubernetes nodes.
+type Provider interface {
+	// InstanceID returns the key under which ListTags reports the tags of the node.
+	InstanceID(node *corev1.Node) (string, error)
+	// ListTags returns the tags of the given nodes keyed by their InstanceID.
+	// When only some lookups fail it returns the other tags with a *PartialError.
+	ListTags(nodes []*corev1.Node) (map[string][]*Tag, error)
+	// Capabilities reports what the provider supports.
+	Capabilities() Capabilities
+}
+
+// Capabilities describes optional behaviour of a Provider.
+type Capabilities struct {
+	// BatchLookup is true when a single ListTags call can resolve many nodes.
+	BatchLookup bool
+	// FromNodeObject is true when tags are derived from the node object itself,
+	// so node updates have to be re-evaluated.
+	FromNodeObject bool
+}
+
+// NodeMatcher selects the nodes affected by a tag change.
+type NodeMatcher func(node *corev1.Node) bool
+
+// Watcher is implemented by providers which notice tag changes by themselves.
+type Watcher interface {
+	// Watch runs until stopCh is closed and reports every change through changed.
+	Watch(stopCh <-chan struct{}, changed func(NodeMatcher))
+}
+
+type Tag struct {
+	Key   string
+	Value string
+}
+
+// PartialError reports instances whose lookup failed while tags of the others were returned.
+type PartialError struct {
+	Failed []string
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("lookup of %d instances failed: %s", len(e.Failed), e.Err.Error())
+}
+
+// merge adds the failures of other, both may be nil
+func (e *PartialError) merge(other *PartialError) *PartialError {
+	if e == nil {
+		return other
+	}
+	if other != nil {
+		e.Failed = append(e.Failed, other.Failed...)
+	}
+	return e
+}
+
+// failedSet returns the failed instance ids, e may be nil
+func (e *PartialError) failedSet() map[string]bool {
+	failed := map[string]bool{}
+	if e != nil {
+		for _, id := range e.Failed {
+			failed[id] = true
+		}
+	}
+	return failed
+}
+
+// orNil avoids returning a typed nil pointer as a non nil error
+func (e *PartialError) orNil() error {
+	if e == nil {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,244 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// Recording is a single ListTags call, the record file holds one per line as JSON.
+type Recording struct {
+	Start        time.Time                    `json:"start"`
+	End          time.Time                    `json:"end"`
+	Capabilities Capabilities                 `json:"capabilities"`
+	Nodes        []RecordedNode               `json:"nodes"`
+	Tags         map[string]map[string]string `json:"tags,omitempty"`
+	// Failed instances of a partial error, when Error is set without them the whole call failed
+	Failed []string `json:"failed,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// RecordedNode is a node passed to ListTags.
+type RecordedNode struct {
+	Name       string `json:"name"`
+	ProviderID string `json:"providerID,omitempty"`
+	InstanceID string `json:"instanceID,omitempty"`
+}
+
+// RecordConfig contains configuration to create a new recording provider.
+type RecordConfig struct {
+	// File the lookups are appended to, it is created when missing
+	File string
+}
+
+// RecordingProvider writes every lookup of another provider to a file, replay it with ReplayProvider.
+type RecordingProvider struct {
+	provider Provider
+	now      func() time.Time
+
+	lock sync.Mutex
+	out  io.Writer
+}
+
+func NewRecordingProvider(p Provider, recordConfig RecordConfig) (*RecordingProvider, error) {
+	out, err := os.OpenFile(recordConfig.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Recording tag lookups to %s", recordConfig.File)
+	return &RecordingProvider{provider: p, now: time.Now, out: out}, nil
+}
+
+func (r *RecordingProvider) InstanceID(node *corev1.Node) (string, error) {
+	return r.provider.InstanceID(node)
+}
+
+func (r *RecordingProvider) Capabilities() Capabilities {
+	return r.provider.Capabilities()
+}
+
+func (r *RecordingProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	recording := &Recording{Start: r.now(), Capabilities: r.provider.Capabilities()}
+	tags, err := r.provider.ListTags(nodes)
+	recording.End = r.now()
+
+	for _, no := range nodes {
+		// a node without instance id fails the lookup itself, the error is recorded there
+		id, _ := r.provider.InstanceID(no)
+		recording.Nodes = append(recording.Nodes, RecordedNode{Name: no.GetName(), ProviderID: no.Spec.ProviderID, InstanceID: id})
+	}
+	for id, t := range tags {
+		if recording.Tags == nil {
+			recording.Tags = map[string]map[string]string{}
+		}
+		recording.Tags[id] = map[string]string{}
+		for _, tag := range t {
+			recording.Tags[id][tag.Key] = tag.Value
+		}
+	}
+	if err != nil {
+		recording.Error = err.Error()
+		if partial, ok := err.(*PartialError); ok {
+			recording.Failed = partial.Failed
+		}
+	}
+	r.write(recording)
+	return tags, err
+}
+
+// Watch forwards changes of the wrapped provider.
+func (r *RecordingProvider) Watch(stopCh <-chan struct{}, changed func(NodeMatcher)) {
+	watcher, ok := r.provider.(Watcher)
+	if !ok {
+		<-stopCh
+		return
+	}
+	watcher.Watch(stopCh, changed)
+}
+
+// write appends the recording, a failed write does not fail the lookup
+func (r *RecordingProvider) write(recording *Recording) {
+	line, err := json.Marshal(recording)
+	if err != nil {
+		klog.Errorf("Error recording lookup: %s", err.Error())
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, err := r.out.Write(append(line, '\n')); err != nil {
+		klog.Errorf("Error recording lookup: %s", err.Error())
+	}
+}
+
+// ReplayConfig contains configuration to create a new replay provider.
+type ReplayConfig struct {
+	// File written by a RecordingProvider
+	File string
+	// At replays the state at this time, lookups started later are ignored. Zero replays the whole file.
+	At time.Time
+}
+
+// ReplayProvider serves the last recorded lookup of every instance, so the controller can be run
+// offline against tags as they were during an incident.
+type ReplayProvider struct {
+	capabilities Capabilities
+	// instanceIDs of recorded nodes by ProviderID and by name
+	byProviderID map[string]string
+	byName       map[string]string
+	lookups      map[string]*replayedLookup
+}
+
+type replayedLookup struct {
+	tags []*Tag
+	err  string
+}
+
+func NewReplayProvider(replayConfig ReplayConfig) (*ReplayProvider, error) {
+	in, err := os.Open(replayConfig.File)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	p := &ReplayProvider{
+		byProviderID: map[string]string{},
+		byName:       map[string]string{},
+		lookups:      map[string]*replayedLookup{},
+	}
+	decoder := json.NewDecoder(in)
+	replayed := 0
+	for {
+		recording := &Recording{}
+		if err := decoder.Decode(recording); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid recording %d of %s: %s", replayed+1, replayConfig.File, err.Error())
+		}
+		if !replayConfig.At.IsZero() && recording.Start.After(replayConfig.At) {
+			continue
+		}
+		p.add(recording)
+		replayed++
+	}
+	klog.Infof("Replaying %d lookups of %d instances from %s", replayed, len(p.lookups), replayConfig.File)
+	return p, nil
+}
+
+// add applies a recording over the earlier ones
+func (p *ReplayProvider) add(recording *Recording) {
+	p.capabilities = recording.Capabilities
+	failed := map[string]bool{}
+	for _, id := range recording.Failed {
+		failed[id] = true
+	}
+	for _, no := range recording.Nodes {
+		if no.InstanceID == "" {
+			continue
+		}
+		if no.ProviderID != "" {
+			p.byProviderID[no.ProviderID] = no.InstanceID
+		}
+		p.byName[no.Name] = no.InstanceID
+
+		lookup := &replayedLookup{}
+		if failed[no.InstanceID] || recording.Error != "" && len(recording.Failed) == 0 {
+			lookup.err = recording.Error
+		}
+		for _, key := range sortedTagKeys(recording.Tags[no.InstanceID]) {
+			lookup.tags = append(lookup.tags, &Tag{Key: key, Value: recording.Tags[no.InstanceID][key]})
+		}
+		p.lookups[no.InstanceID] = lookup
+	}
+}
+
+// InstanceID returns the recorded instance id of a node, by ProviderID first and then by name.
+func (p *ReplayProvider) InstanceID(node *corev1.Node) (string, error) {
+	if id, ok := p.byProviderID[node.Spec.ProviderID]; ok && node.Spec.ProviderID != "" {
+		return id, nil
+	}
+	if id, ok := p.byName[node.GetName()]; ok {
+		return id, nil
+	}
+	return "", fmt.Errorf("no recorded lookup of node %s", node.GetName())
+}
+
+func (p *ReplayProvider) Capabilities() Capabilities {
+	return p.capabilities
+}
+
+// ListTags returns the recorded tags, recorded failures fail again.
+func (p *ReplayProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	result := make(map[string][]*Tag)
+	var partial *PartialError
+	for _, no := range nodes {
+		id, err := p.InstanceID(no)
+		if err != nil {
+			return nil, err
+		}
+		lookup := p.lookups[id]
+		if lookup.err != "" {
+			partial = partial.merge(&PartialError{Failed: []string{id}, Err: fmt.Errorf("replayed: %s", lookup.err)})
+			continue
+		}
+		if len(lookup.tags) > 0 {
+			result[id] = lookup.tags
+		}
+	}
+	return result, partial.orNil()
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
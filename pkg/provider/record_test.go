@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// failingProvider fails lookups of the given nodes with a partial error
+type failingProvider struct {
+	countingProvider
+	failed map[string]bool
+}
+
+func (f *failingProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	result, _ := f.countingProvider.ListTags(nodes)
+	var partial *PartialError
+	for _, no := range nodes {
+		if f.failed[no.GetName()] {
+			delete(result, no.GetName())
+			partial = partial.merge(&PartialError{Failed: []string{no.GetName()}, Err: errors.New("RequestLimitExceeded")})
+		}
+	}
+	return result, partial.orNil()
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "record")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "lookups.json")
+
+	inner := &failingProvider{countingProvider: countingProvider{tags: map[string][]*Tag{
+		"node-1": {{Key: "role", Value: "worker"}, {Key: "pool", Value: "blue"}},
+	}}}
+	r, err := NewRecordingProvider(inner, RecordConfig{File: file})
+	assert.NoError(t, err)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	r.now = func() time.Time { return now }
+
+	node1, node2 := newNode("node-1", "aws:///us-west-2c/node-1"), newNode("node-2", "")
+	nodes := []*corev1.Node{node1, node2}
+	r.ListTags(nodes)
+
+	// the tags changed and node-2 failed since
+	now = now.Add(time.Hour)
+	inner.tags["node-1"] = []*Tag{{Key: "role", Value: "db"}}
+	inner.failed = map[string]bool{"node-2": true}
+	_, err = r.ListTags(nodes)
+	assert.IsType(t, &PartialError{}, err)
+
+	p, err := NewReplayProvider(ReplayConfig{File: file})
+	assert.NoError(t, err)
+	assert.Equal(t, Capabilities{BatchLookup: true}, p.Capabilities())
+	tags, err := p.ListTags(nodes)
+	assert.Equal(t, map[string][]*Tag{"node-1": {{Key: "role", Value: "db"}}}, tags)
+	assert.Equal(t, []string{"node-2"}, err.(*PartialError).Failed)
+
+	// as it was before the incident
+	p, err = NewReplayProvider(ReplayConfig{File: file, At: start.Add(time.Minute)})
+	assert.NoError(t, err)
+	tags, err = p.ListTags(nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]*Tag{"node-1": {{Key: "pool", Value: "blue"}, {Key: "role", Value: "worker"}}}, tags)
+
+	_, err = p.InstanceID(newNode("node-3", ""))
+	assert.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// StaticConfig contains configuration to create a new static provider.
+type StaticConfig struct {
+	// File is a YAML or JSON file with StaticRules, a mounted ConfigMap works too
+	File string
+	// Interval between checks of the file for changes
+	Interval time.Duration
+}
+
+// StaticRules maps nodes to tags.
+//
+//	rules:
+//	- hostname: "rack1-*"
+//	  tags:
+//	    devops.apixio.com/rack: rack1
+type StaticRules struct {
+	Rules []StaticRule `json:"rules"`
+}
+
+// StaticRule applies its tags to nodes matching every pattern set on it.
+// Patterns are globs where * matches any text including '/', ? a single character and [a-z] a class.
+// Tags of later rules override earlier ones.
+type StaticRule struct {
+	Name       string            `json:"name,omitempty"`
+	ProviderID string            `json:"providerID,omitempty"`
+	Hostname   string            `json:"hostname,omitempty"`
+	Tags       map[string]string `json:"tags"`
+
+	// compiled patterns, nil matches everything
+	name, providerID, hostname *regexp.Regexp
+}
+
+// StaticProvider serves tags from a file for nodes without any cloud tags.
+type StaticProvider struct {
+	config StaticConfig
+
+	lock  sync.RWMutex
+	raw   []byte
+	rules *StaticRules
+}
+
+func NewStaticProvider(staticConfig StaticConfig) (*StaticProvider, error) {
+	if staticConfig.Interval == 0 {
+		staticConfig.Interval = 30 * time.Second
+	}
+	p := &StaticProvider{config: staticConfig}
+	if _, _, err := p.reload(); err != nil {
+		return nil, err
+	}
+	klog.Infof("Loaded %d static rules from %s", len(p.rules.Rules), staticConfig.File)
+	return p, nil
+}
+
+// reload reads the file again, it reports the previous rules when the content changed
+func (p *StaticProvider) reload() (*StaticRules, bool, error) {
+	raw, err := ioutil.ReadFile(p.config.File)
+	if err != nil {
+		return nil, false, err
+	}
+
+	p.lock.RLock()
+	unchanged := p.rules != nil && bytes.Equal(raw, p.raw)
+	p.lock.RUnlock()
+	if unchanged {
+		return nil, false, nil
+	}
+
+	rules, err := parseStaticRules(raw)
+	if err != nil {
+		return nil, false, fmt.Errorf("can not parse %s: %s", p.config.File, err.Error())
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	old := p.rules
+	p.raw = raw
+	p.rules = rules
+	return old, true, nil
+}
+
+func parseStaticRules(raw []byte) (*StaticRules, error) {
+	rules := &StaticRules{}
+	if err := yaml.UnmarshalStrict(raw, rules); err != nil {
+		return nil, err
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		for _, pattern := range []struct {
+			glob     string
+			compiled **regexp.Regexp
+		}{{rule.Name, &rule.name}, {rule.ProviderID, &rule.providerID}, {rule.Hostname, &rule.hostname}} {
+			compiled, err := compileGlob(pattern.glob)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: bad pattern %q: %s", i, pattern.glob, err.Error())
+			}
+			*pattern.compiled = compiled
+		}
+	}
+	return rules, nil
+}
+
+// InstanceID returns the node name, rules are evaluated per node.
+func (p *StaticProvider) InstanceID(node *corev1.Node) (string, error) {
+	return node.GetName(), nil
+}
+
+func (p *StaticProvider) Capabilities() Capabilities {
+	return Capabilities{BatchLookup: true}
+}
+
+func (p *StaticProvider) ListTags(nodes []*corev1.Node) (map[string][]*Tag, error) {
+	p.lock.RLock()
+	rules := p.rules
+	p.lock.RUnlock()
+
+	tags := make(map[string][]*Tag)
+	for _, no := range nodes {
+		tags[no.GetName()] = toTagList(rules.tagsOf(no))
+	}
+	return tags, nil
+}
+
+// Watch checks the file on every interval and reports nodes whose tags changed.
+func (p *StaticProvider) Watch(stopCh <-chan struct{}, changed func(NodeMatcher)) {
+	wait.Until(func() {
+		old, ok, err := p.reload()
+		if err != nil {
+			klog.Errorf("[static] Keep previous rules. Reason: %s", err.Error())
+			return
+		}
+		if !ok {
+			return
+		}
+
+		p.lock.RLock()
+		current := p.rules
+		p.lock.RUnlock()
+
+		klog.Infof("[static] Reloaded %d rules from %s", len(current.Rules), p.config.File)
+		changed(func(node *corev1.Node) bool {
+			return !reflect.DeepEqual(old.tagsOf(node), current.tagsOf(node))
+		})
+	}, p.config.Interval, stopCh)
+}
+
+func (r *StaticRules) tagsOf(node *corev1.Node) map[string]string {
+	result := map[string]string{}
+	for _, rule := range r.Rules {
+		if !rule.matches(node) {
+			continue
+		}
+		for k, v := range rule.Tags {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func (r *StaticRule) matches(node *corev1.Node) bool {
+	if r.Name == "" && r.ProviderID == "" && r.Hostname == "" {
+		return false
+	}
+	return matchPattern(r.name, node.GetName()) &&
+		matchPattern(r.providerID, node.Spec.ProviderID) &&
+		matchPattern(r.hostname, nodeHostname(node))
+}
+
+// matchPattern treats a missing pattern as match-all
+func matchPattern(pattern *regexp.Regexp, value string) bool {
+	return pattern == nil || pattern.MatchString(value)
+}
+
+// compileGlob turns a glob into an anchored regexp, unlike filepath.Match * also matches '/'
+// so aws://* matches every ProviderID. An empty glob compiles to nil.
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	if glob == "" {
+		return nil, nil
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end <= 0 {
+				return nil, fmt.Errorf("unterminated or empty character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 == len(glob) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+func nodeHostname(node *corev1.Node) string {
+	if hostname, ok := node.Labels[corev1.LabelHostname]; ok {
+		return hostname
+	}
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeHostName {
+			return addr.Address
+		}
+	}
+	return ""
+}
+
+func toTagList(tags map[string]string) []*Tag {
+	result := make([]*Tag, 0, len(tags))
+	for k, v := range tags {
+		result = append(result, &Tag{Key: k, Value: v})
+	}
+	return result
+}
@@ -0,0 +1,108 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const staticRules = `
+rules:
+- hostname: "rack1-*"
+  tags:
+    devops.apixio.com/rack: rack1
+- name: rack1-node-2
+  tags:
+    devops.apixio.com/rack: override
+    devops.apixio.com/gpu: "true"
+`
+
+func writeFile(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticListTags(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "static")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.yaml")
+	writeFile(t, file, staticRules)
+
+	p, err := NewStaticProvider(StaticConfig{File: file})
+	assert.NoError(t, err)
+
+	node1 := newNode("rack1-node-1", "")
+	node1.Labels = map[string]string{corev1.LabelHostname: "rack1-node-1"}
+	node2 := newNode("rack1-node-2", "")
+	node2.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeHostName, Address: "rack1-node-2"}}
+	node3 := newNode("rack2-node-1", "")
+
+	tags, err := p.ListTags([]*corev1.Node{node1, node2, node3})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tag{{Key: "devops.apixio.com/rack", Value: "rack1"}}, tags["rack1-node-1"])
+	assert.ElementsMatch(t, []*Tag{
+		{Key: "devops.apixio.com/rack", Value: "override"},
+		{Key: "devops.apixio.com/gpu", Value: "true"},
+	}, tags["rack1-node-2"])
+	assert.Empty(t, tags["rack2-node-1"])
+}
+
+func TestStaticPatterns(t *testing.T) {
+	rules, err := parseStaticRules([]byte(`
+rules:
+- providerID: "aws://*"
+  tags:
+    devops.apixio.com/cloud: aws
+- providerID: "aws:///us-west-2[ab]/*"
+  name: "node-?"
+  tags:
+    devops.apixio.com/zone: ab
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"devops.apixio.com/cloud": "aws", "devops.apixio.com/zone": "ab"},
+		rules.tagsOf(newNode("node-1", "aws:///us-west-2a/i-1")))
+	assert.Equal(t, map[string]string{"devops.apixio.com/cloud": "aws"}, rules.tagsOf(newNode("node-1", "aws:///us-west-2c/i-1")))
+	assert.Empty(t, rules.tagsOf(newNode("node-1", "gce://project/zone/node-1")))
+
+	for _, pattern := range []string{"rack[1", "rack[]", `rack\`} {
+		_, err := parseStaticRules([]byte("rules:\n- name: '" + pattern + "'\n  tags: {}\n"))
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestStaticWatch(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "static")
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.json")
+	writeFile(t, file, `{"rules": [{"name": "node-1", "tags": {"devops.apixio.com/rack": "rack1"}}]}`)
+
+	p, err := NewStaticProvider(StaticConfig{File: file, Interval: 10 * time.Millisecond})
+	assert.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	matchers := make(chan NodeMatcher, 1)
+	go p.Watch(stopCh, func(match NodeMatcher) { matchers <- match })
+
+	writeFile(t, file, `{"rules": [{"name": "node-1", "tags": {"devops.apixio.com/rack": "rack2"}}]}`)
+
+	select {
+	case match := <-matchers:
+		assert.True(t, match(newNode("node-1", "")))
+		assert.False(t, match(newNode("node-2", "")))
+	case <-time.After(5 * time.Second):
+		t.Fatal("change was not reported")
+	}
+
+	// a broken file keeps the previous rules
+	writeFile(t, file, `{"rules": [`)
+	time.Sleep(50 * time.Millisecond)
+	tags, _ := p.ListTags([]*corev1.Node{newNode("node-1", "")})
+	assert.Equal(t, []*Tag{{Key: "devops.apixio.com/rack", Value: "rack2"}}, tags["node-1"])
+}